@@ -0,0 +1,97 @@
+package gitremote
+
+import (
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// Background runs work a helper starts ahead of the command which needs
+// it, like prefetching objects during list while Git is still reading the
+// response, or writing a pack after fetch returned. Responses are not
+// affected: commands still answer Git one at a time, in order.
+//
+// Tasks are identified by a key. A later command joins a task with Wait.
+// Tasks run with the session context, so they are cancelled when the
+// session is, and Run waits for all of them before it returns. The error
+// of a task nobody waited for is returned by Run.
+//
+// Background is safe for concurrent use. The runner sets it on the Config
+// of every command.
+type Background struct {
+	ctx   context.Context
+	mtx   sync.Mutex
+	wg    sync.WaitGroup
+	tasks map[string]*backgroundTask
+}
+
+type backgroundTask struct {
+	done chan struct{}
+	err  error
+}
+
+func newBackground(ctx context.Context) *Background {
+	return &Background{ctx: ctx, tasks: make(map[string]*backgroundTask)}
+}
+
+// Go starts fn under key. Nothing is started when a task with the same key
+// is already pending; Go then returns false.
+func (b *Background) Go(key string, fn func(ctx context.Context) error) bool {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if _, ok := b.tasks[key]; ok {
+		return false
+	}
+
+	task := &backgroundTask{done: make(chan struct{})}
+	b.tasks[key] = task
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		defer close(task.done)
+		task.err = fn(b.ctx)
+	}()
+
+	return true
+}
+
+// Wait waits for the task started under key and returns its error. The
+// task is forgotten, so the key can be used again. Wait returns nil when
+// no task is pending under key.
+func (b *Background) Wait(ctx context.Context, key string) error {
+	b.mtx.Lock()
+	task, ok := b.tasks[key]
+	delete(b.tasks, key)
+	b.mtx.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-task.done:
+		return task.err
+	}
+}
+
+// wait waits for all tasks and returns the first error of a task which
+// nobody waited for.
+func (b *Background) wait() error {
+	b.wg.Wait()
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	for key, task := range b.tasks {
+		delete(b.tasks, key)
+		if task.err != nil {
+			return task.err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,94 @@
+package gitremote
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestBackground(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		b       = newBackground(ctx)
+		release = make(chan struct{})
+		errTask = errors.New("task failed")
+	)
+
+	if !b.Go("a", func(ctx context.Context) error { <-release; return errTask }) {
+		t.Fatal("Go(a) did not start the task")
+	}
+	if b.Go("a", func(ctx context.Context) error { return nil }) {
+		t.Error("Go(a) started a second task under the same key")
+	}
+
+	close(release)
+	if err := b.Wait(ctx, "a"); err != errTask {
+		t.Errorf("Wait(a) = %v, want %v", err, errTask)
+	}
+	if err := b.Wait(ctx, "a"); err != nil {
+		t.Errorf("second Wait(a) = %v, want nil", err)
+	}
+	if err := b.Wait(ctx, "unknown"); err != nil {
+		t.Errorf("Wait(unknown) = %v, want nil", err)
+	}
+
+	b.Go("b", func(ctx context.Context) error { return errTask })
+	if err := b.wait(); err != errTask {
+		t.Errorf("wait() = %v, want the error of the task nobody waited for", err)
+	}
+}
+
+// prefetchHelper starts a background task in List which the following
+// Fetch waits for.
+type prefetchHelper struct {
+	BaseHelper
+	release chan struct{}
+	fail    bool
+}
+
+func (h *prefetchHelper) Capabilities() Capabilities {
+	return Capabilities{Mandatory: CapFetch}
+}
+
+func (h *prefetchHelper) List(ctx context.Context, cmd *CmdList) ([]ListRef, error) {
+	cmd.Config.Background.Go("prefetch", func(ctx context.Context) error {
+		<-h.release
+		if h.fail {
+			return errors.New("prefetch failed")
+		}
+		return nil
+	})
+	return []ListRef{{Name: "refs/heads/master", Hash: SHA1.ZeroHash()}}, nil
+}
+
+func (h *prefetchHelper) Fetch(ctx context.Context, cmd *CmdFetch) (FetchResult, error) {
+	close(h.release)
+	return FetchResult{}, cmd.Config.Background.Wait(ctx, "prefetch")
+}
+
+func TestBackgroundSession(t *testing.T) {
+	in := "list\nfetch " + SHA1.ZeroHash() + " refs/heads/master\n\n\n"
+
+	var out bytes.Buffer
+	h := &prefetchHelper{release: make(chan struct{})}
+	err := Run(context.Background(), Config{Helper: h, Stdin: strings.NewReader(in), Stdout: &out})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := SHA1.ZeroHash() + " refs/heads/master\n\n\n"
+	if out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+
+	// a failed task nobody waited for fails the session
+	h = &prefetchHelper{release: make(chan struct{}), fail: true}
+	close(h.release)
+	err = Run(context.Background(), Config{Helper: h, Stdin: strings.NewReader("list\n\n"), Stdout: &out})
+	if err == nil || err.Error() != "prefetch failed" {
+		t.Errorf("got %v, want the error of the prefetch", err)
+	}
+}
@@ -0,0 +1,106 @@
+package gitremote
+
+import (
+	"errors"
+	"io"
+
+	"golang.org/x/net/context"
+
+	"github.com/fd/go-git-remote-helper/fastimport"
+)
+
+// ErrUnsupportedCommand is returned by helpers for commands they do not
+// implement. The runner turns it into the response Git expects where the
+// protocol has one (fallback for connect, unsupported for option, error
+// lines for push and export) and ends the session otherwise.
+var ErrUnsupportedCommand = errors.New("unsupported command")
+
+// BaseHelper implements every Helper method except Capabilities by
+// returning ErrUnsupportedCommand (or ErrUnsupportedOption). Embed it and
+// override the commands the helper supports.
+type BaseHelper struct{}
+
+// SetOption declines every option. The runner still accepts the options it
+// acts on itself (progress, verbosity, object-format, ...) and exposes them
+// on Config.Options.
+func (BaseHelper) SetOption(key, value string) error {
+	return ErrUnsupportedOption
+}
+
+func (BaseHelper) List(ctx context.Context, cmd *CmdList) ([]ListRef, error) {
+	return nil, ErrUnsupportedCommand
+}
+
+func (BaseHelper) Fetch(ctx context.Context, cmd *CmdFetch) (FetchResult, error) {
+	return FetchResult{}, ErrUnsupportedCommand
+}
+
+func (BaseHelper) Push(ctx context.Context, cmd *CmdPush) error {
+	return ErrUnsupportedCommand
+}
+
+func (BaseHelper) Export(ctx context.Context, cmd *CmdExport) error {
+	return ErrUnsupportedCommand
+}
+
+func (BaseHelper) Import(ctx context.Context, cmd *CmdImport) error {
+	return ErrUnsupportedCommand
+}
+
+func (BaseHelper) Connect(ctx context.Context, cmd *CmdConnect) error {
+	return ErrUnsupportedCommand
+}
+
+func (BaseHelper) StatelessConnect(ctx context.Context, cmd *CmdStatelessConnect) (StatelessConn, error) {
+	return nil, ErrUnsupportedCommand
+}
+
+func (BaseHelper) Unknown(ctx context.Context, cmd *CmdUnknown) error {
+	return ErrUnsupportedCommand
+}
+
+// rejectExport consumes the fast-export stream and rejects every ref it
+// mentions.
+func rejectExport(r *runner, reason error) error {
+	var (
+		fr   = fastimport.NewReader(r.br)
+		refs []string
+		seen = map[string]bool{}
+	)
+
+	add := func(ref string) {
+		if !seen[ref] {
+			seen[ref] = true
+			refs = append(refs, ref)
+		}
+	}
+
+	for {
+		cmd, err := fr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		switch cmd := cmd.(type) {
+		case *fastimport.Commit:
+			add(cmd.Ref)
+		case *fastimport.Reset:
+			add(cmd.Ref)
+		case *fastimport.Tag:
+			add("refs/tags/" + cmd.Name)
+		}
+	}
+
+	for _, ref := range refs {
+		_, err := r.bw.WriteString("error " + ref + " " + quoteMessage(reason.Error()) + "\n")
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := r.bw.WriteRune('\n')
+	return err
+}
@@ -2,6 +2,7 @@ package gitremote
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 )
 
@@ -11,6 +12,10 @@ type Capabilities struct {
 	Refspecs    []string
 	ExportMarks string
 	ImportMarks string
+
+	// ObjectFormat is the object format of the remote repository. It
+	// defaults to SHA1.
+	ObjectFormat ObjectFormat
 }
 
 type Capability uint
@@ -29,123 +34,166 @@ const (
 	CapNoPrivateUpdate
 	CapCheckConnectivity
 	CapSignedTags
+	CapStatelessConnect
+	CapObjectFormat
 )
 
+type ErrUnsupportedCapability Capability
+
+func (e ErrUnsupportedCapability) Error() string {
+	return fmt.Sprintf("unsupported capability: %s", Capability(e))
+}
+
+// capabilityInfo describes how a capability is advertised to Git.
+type capabilityInfo struct {
+	Cap  Capability
+	Name string
+
+	// Args returns the arguments of the capability. Each argument is
+	// advertised on its own line. Nil when the capability takes no
+	// arguments.
+	Args func(c Capabilities) []string
+}
+
+// capabilityTable lists all known capabilities in the order they are
+// advertised.
+var capabilityTable = []capabilityInfo{
+	{Cap: CapConnect, Name: "connect"},
+	{Cap: CapStatelessConnect, Name: "stateless-connect"},
+	{Cap: CapPush, Name: "push"},
+	{Cap: CapFetch, Name: "fetch"},
+	{Cap: CapExport, Name: "export"},
+	{Cap: CapImport, Name: "import"},
+	{Cap: CapOption, Name: "option"},
+	{Cap: CapBidiImport, Name: "bidi-import"},
+	{Cap: CapExportMarks, Name: "export-marks", Args: func(c Capabilities) []string {
+		return []string{c.ExportMarks}
+	}},
+	{Cap: CapImportMarks, Name: "import-marks", Args: func(c Capabilities) []string {
+		return []string{c.ImportMarks}
+	}},
+	{Cap: CapRefspec, Name: "refspec", Args: func(c Capabilities) []string {
+		return c.Refspecs
+	}},
+	{Cap: CapNoPrivateUpdate, Name: "no-private-update"},
+	{Cap: CapCheckConnectivity, Name: "check-connectivity"},
+	{Cap: CapSignedTags, Name: "signed-tags"},
+	{Cap: CapObjectFormat, Name: "object-format"},
+}
+
+func lookupCapability(c Capability) (capabilityInfo, bool) {
+	for _, info := range capabilityTable {
+		if info.Cap == c {
+			return info, true
+		}
+	}
+	return capabilityInfo{}, false
+}
+
+// has returns true when cap is either optional or mandatory.
+func (c Capabilities) has(cap Capability) bool {
+	return (c.Optional|c.Mandatory)&cap != 0
+}
+
 func (c Capability) String() string {
-	switch c {
-	case CapConnect:
-		return "connect"
-	case CapPush:
-		return "push"
-	case CapFetch:
-		return "fetch"
-	case CapExport:
-		return "export"
-	case CapImport:
-		return "import"
-	case CapOption:
-		return "option"
-	case CapRefspec:
-		return "refspec"
-	case CapBidiImport:
-		return "bidi-import"
-	case CapExportMarks:
-		return "export-marks"
-	case CapImportMarks:
-		return "import-marks"
-	case CapNoPrivateUpdate:
-		return "no-private-update"
-	case CapCheckConnectivity:
-		return "check-connectivity"
-	case CapSignedTags:
-		return "signed-tags"
-	default:
-		panic("unknown Capability")
+	info, ok := lookupCapability(c)
+	if !ok {
+		return fmt.Sprintf("Capability(%d)", uint(c))
 	}
+	return info.Name
 }
 
-func (c Capabilities) writeTo(w io.Writer) error {
-	var buf bytes.Buffer
-	var err error
+// Validate returns an error when a capability is set which this library
+// does not know or when a capability is missing its arguments.
+func (c Capabilities) Validate() error {
+	var (
+		set   = c.Optional | c.Mandatory
+		known Capability
+	)
 
-	writeRune := func(r rune) {
-		if err != nil {
-			return
+	for _, info := range capabilityTable {
+		known |= info.Cap
+
+		if set&info.Cap == 0 {
+			continue
 		}
 
-		_, err = buf.WriteRune(r)
+		if info.Args != nil {
+			args := info.Args(c)
+			if len(args) == 0 {
+				return fmt.Errorf("capability %s requires an argument", info.Name)
+			}
+			for _, arg := range args {
+				if arg == "" {
+					return fmt.Errorf("capability %s requires an argument", info.Name)
+				}
+			}
+		}
 	}
 
-	writeString := func(s string) {
-		if err != nil {
-			return
-		}
+	if unknown := set &^ known; unknown != 0 {
+		return ErrUnsupportedCapability(unknown & -unknown)
+	}
 
-		_, err = buf.WriteString(s)
+	if c.ObjectFormat != "" && c.ObjectFormat.HexSize() == 0 {
+		return ErrUnsupportedObjectFormat(c.ObjectFormat)
 	}
 
-	callExtra := func(extra func()) {
-		if err != nil {
-			return
-		}
-		if extra == nil {
-			return
-		}
+	// Git assumes sha1 unless the object-format capability lets the
+	// helper announce another format in the list output.
+	if c.ObjectFormat != "" && c.ObjectFormat != SHA1 && set&CapObjectFormat == 0 {
+		return fmt.Errorf("object format %s requires capability %s", c.ObjectFormat, CapObjectFormat)
+	}
 
-		extra()
+	if set&CapBidiImport != 0 && set&CapImport == 0 {
+		return fmt.Errorf("capability %s requires %s", CapBidiImport, CapImport)
 	}
 
-	writeCap := func(cap Capability, extra func()) {
-		if err != nil {
-			return
-		}
+	return nil
+}
 
-		if c.Mandatory&cap == cap {
-			writeRune('*')
-			writeString(cap.String())
-			callExtra(extra)
-			writeRune('\n')
-			return
-		}
+func (c Capabilities) writeTo(w io.Writer) error {
+	var buf bytes.Buffer
 
-		if c.Optional&cap == cap {
-			writeString(cap.String())
-			callExtra(extra)
-			writeRune('\n')
-			return
-		}
+	err := c.Validate()
+	if err != nil {
+		return err
 	}
 
-	writeCap(CapConnect, nil)
-	writeCap(CapPush, nil)
-	writeCap(CapFetch, nil)
-	writeCap(CapExport, nil)
-	writeCap(CapImport, nil)
-	writeCap(CapOption, nil)
-	writeCap(CapBidiImport, nil)
-
-	writeCap(CapExportMarks, func() {
-		writeRune(' ')
-		writeString(c.ExportMarks)
-	})
-
-	writeCap(CapImportMarks, func() {
-		writeRune(' ')
-		writeString(c.ImportMarks)
-	})
-
-	for _, refspec := range c.Refspecs {
-		writeCap(CapRefspec, func() {
-			writeRune(' ')
-			writeString(refspec)
-		})
+	writeLine := func(prefix, name, arg string) {
+		buf.WriteString(prefix)
+		buf.WriteString(name)
+		if arg != "" {
+			buf.WriteRune(' ')
+			buf.WriteString(arg)
+		}
+		buf.WriteRune('\n')
 	}
 
-	writeRune('\n')
+	for _, info := range capabilityTable {
+		var prefix string
+
+		switch {
+		case c.Mandatory&info.Cap == info.Cap:
+			prefix = "*"
+		case c.Optional&info.Cap == info.Cap:
+			prefix = ""
+		default:
+			continue
+		}
 
-	if err == nil {
-		_, err = buf.WriteTo(w)
+		if info.Args == nil {
+			writeLine(prefix, info.Name, "")
+			continue
+		}
+
+		for _, arg := range info.Args(c) {
+			writeLine(prefix, info.Name, arg)
+		}
 	}
 
+	buf.WriteRune('\n')
+
+	_, err = buf.WriteTo(w)
 	return err
 }
@@ -0,0 +1,32 @@
+package gitremote
+
+import (
+	"testing"
+)
+
+func TestCapabilitiesValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		caps  Capabilities
+		valid bool
+	}{
+		{"fetch", Capabilities{Mandatory: CapFetch}, true},
+		{"sha1 without object-format", Capabilities{Mandatory: CapFetch, ObjectFormat: SHA1}, true},
+		{"sha256 with object-format", Capabilities{Mandatory: CapFetch, Optional: CapObjectFormat, ObjectFormat: SHA256}, true},
+		{"sha256 without object-format", Capabilities{Mandatory: CapFetch, ObjectFormat: SHA256}, false},
+		{"unknown object format", Capabilities{Mandatory: CapFetch, Optional: CapObjectFormat, ObjectFormat: "md5"}, false},
+		{"unknown capability", Capabilities{Mandatory: CapFetch, Optional: CapObjectFormat << 1}, false},
+		{"export-marks without a path", Capabilities{Mandatory: CapExport, Optional: CapExportMarks}, false},
+		{"bidi-import without import", Capabilities{Mandatory: CapBidiImport}, false},
+	}
+
+	for _, test := range tests {
+		err := test.caps.Validate()
+		if test.valid && err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+}
@@ -36,12 +36,62 @@ func (r *runner) readCommands(ctx context.Context) <-chan Command {
 				return
 			case out <- cmd:
 			}
+
+			// commands which read from stdin themselves own the stream
+			// until they are done.
+			if holdsInput(cmd) {
+				select {
+				case <-ctx.Done():
+					return
+				case <-r.released:
+				}
+			}
+
+			switch cmd.(type) {
+			case *CmdConnect, *CmdStatelessConnect:
+				r.connected = true
+			}
 		}
 	}()
 
 	return out
 }
 
+// commandName returns the name Git uses for cmd.
+func commandName(cmd Command) string {
+	switch cmd.(type) {
+	case *CmdCapabilities:
+		return "capabilities"
+	case *CmdList:
+		return "list"
+	case *CmdOption:
+		return "option"
+	case *CmdFetch:
+		return "fetch"
+	case *CmdPush:
+		return "push"
+	case *CmdImport:
+		return "import"
+	case *CmdExport:
+		return "export"
+	case *CmdConnect:
+		return "connect"
+	case *CmdStatelessConnect:
+		return "stateless-connect"
+	default:
+		return "unknown"
+	}
+}
+
+func holdsInput(cmd Command) bool {
+	switch cmd.(type) {
+	case *CmdImport, *CmdExport, *CmdConnect, *CmdStatelessConnect:
+		return true
+	default:
+		return false
+	}
+}
+
 func (r *runner) readCommand() (Command, error) {
 	var (
 		cmd       Command
@@ -53,6 +103,10 @@ func (r *runner) readCommand() (Command, error) {
 
 MORE:
 	str, err := r.br.ReadString('\n')
+	if err == io.EOF && state == 0 && str == "" && r.connected {
+		// Git closes stdin without a blank line after a connect session
+		return nil, io.EOF
+	}
 	if err == io.EOF {
 		return nil, io.ErrUnexpectedEOF
 	}
@@ -91,7 +145,10 @@ MORE:
 			if len(parts) != 3 {
 				cmd = &CmdUnknown{Line: str}
 			} else {
-				fetchCmd = &CmdFetch{Objects: map[string]string{parts[1]: parts[2]}}
+				fetchCmd = &CmdFetch{
+					Refs:    []FetchRef{{Hash: parts[1], Name: parts[2]}},
+					Objects: map[string]string{parts[1]: parts[2]},
+				}
 				cmd = fetchCmd
 				state = 1
 				goto MORE
@@ -142,7 +199,15 @@ MORE:
 			if len(parts) != 2 {
 				cmd = &CmdUnknown{Line: str}
 			} else {
-				cmd = &CmdConnect{Service: parts[1], Reader: r.br, Writer: r.bw}
+				cmd = &CmdConnect{Service: parts[1]}
+			}
+
+		case strings.HasPrefix(str, "stateless-connect "):
+			parts := strings.SplitN(str, " ", 2)
+			if len(parts) != 2 {
+				cmd = &CmdUnknown{Line: str}
+			} else {
+				cmd = &CmdStatelessConnect{Service: parts[1]}
 			}
 
 		default:
@@ -160,6 +225,7 @@ MORE:
 			if len(parts) != 3 {
 				return nil, ErrInvalidCommand(str)
 			} else {
+				fetchCmd.Refs = append(fetchCmd.Refs, FetchRef{Hash: parts[1], Name: parts[2]})
 				fetchCmd.Objects[parts[1]] = parts[2]
 				goto MORE
 			}
@@ -169,7 +235,7 @@ MORE:
 
 		}
 
-	case 2: // fetch
+	case 2: // push
 		switch {
 		case str == "":
 			// done
@@ -199,8 +265,7 @@ MORE:
 			}
 
 		default:
-			pushCmd.Options = append(pushCmd.Options, str)
-			goto MORE
+			return nil, ErrInvalidCommand(str)
 
 		}
 
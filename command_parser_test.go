@@ -0,0 +1,48 @@
+package gitremote
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// fetchRecorder records the fetch commands it gets.
+type fetchRecorder struct {
+	transcriptHelper
+	fetches []*CmdFetch
+}
+
+func (h *fetchRecorder) Fetch(ctx context.Context, cmd *CmdFetch) (FetchResult, error) {
+	h.fetches = append(h.fetches, cmd)
+	return FetchResult{}, nil
+}
+
+func TestReadFetch(t *testing.T) {
+	var (
+		a  = strings.Repeat("a", 40)
+		b  = strings.Repeat("b", 40)
+		in = "fetch " + b + " refs/heads/b\nfetch " + a + " refs/heads/a\nfetch " + a + " refs/tags/a\n\n\n"
+		h  = &fetchRecorder{}
+	)
+
+	err := Run(context.Background(), Config{Helper: h, Stdin: strings.NewReader(in), Stdout: &bytes.Buffer{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(h.fetches) != 1 {
+		t.Fatalf("got %d fetch commands, want 1", len(h.fetches))
+	}
+
+	cmd := h.fetches[0]
+	refs := []FetchRef{{b, "refs/heads/b"}, {a, "refs/heads/a"}, {a, "refs/tags/a"}}
+	if !reflect.DeepEqual(cmd.Refs, refs) {
+		t.Errorf("Refs: got %v, want %v", cmd.Refs, refs)
+	}
+	objects := map[string]string{b: "refs/heads/b", a: "refs/tags/a"}
+	if !reflect.DeepEqual(cmd.Objects, objects) {
+		t.Errorf("Objects: got %v, want %v", cmd.Objects, objects)
+	}
+}
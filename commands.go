@@ -1,9 +1,13 @@
 package gitremote
 
 import (
+	"bytes"
 	"io"
 
 	"golang.org/x/net/context"
+
+	"github.com/fd/go-git-remote-helper/fastimport"
+	"github.com/fd/go-git-remote-helper/pktline"
 )
 
 type Command interface {
@@ -23,6 +27,31 @@ type CmdCapabilities struct {
 type CmdList struct {
 	Config  Config
 	ForPush bool
+
+	// Keywords are written before the refs. The runner adds the
+	// object-format keyword when Git asked for it.
+	Keywords []ListKeyword
+}
+
+// SetKeyword adds a keyword line to the response, replacing an earlier
+// keyword with the same name.
+func (c *CmdList) SetKeyword(name, value string) {
+	for i, kw := range c.Keywords {
+		if kw.Name == name {
+			c.Keywords[i].Value = value
+			return
+		}
+	}
+	c.Keywords = append(c.Keywords, ListKeyword{Name: name, Value: value})
+}
+
+func (c *CmdList) hasKeyword(name string) bool {
+	for _, kw := range c.Keywords {
+		if kw.Name == name {
+			return true
+		}
+	}
+	return false
 }
 
 type CmdOption struct {
@@ -32,29 +61,77 @@ type CmdOption struct {
 }
 
 type CmdFetch struct {
-	Config  Config
+	Config Config
+	Refs   []FetchRef // in the order Git sent them
+
+	// Objects holds the requested refs keyed by hash. When several refs
+	// point at the same hash the last one wins.
+	//
+	// Deprecated: use Refs, which keeps the order and every ref.
 	Objects map[string]string
 }
 
+// FetchRef is a single "fetch <hash> <name>" request.
+type FetchRef struct {
+	Hash string
+	Name string
+}
+
+// FetchResult is returned by Helper.Fetch.
+type FetchResult struct {
+	// LockFiles are reported to Git with "lock <file>" lines. Git removes
+	// them once the fetched refs are updated, protecting freshly fetched
+	// packs from gc until then.
+	LockFiles []string
+
+	// ConnectivityOK reports that the fetched objects are self-contained
+	// and connected. It is only reported when Git asked for it with the
+	// check-connectivity option.
+	ConnectivityOK bool
+}
+
 type CmdPush struct {
 	Config  Config
 	Refs    []*PushRef
-	Options []string
+	Options PushOptions
+}
+
+// PushOptions are the options Git set for the push batch.
+type PushOptions struct {
+	ServerOptions []string // git push -o <option>
+	Atomic        bool     // all refs must be updated or none
+	DryRun        bool
+	Force         bool
+	CAS           []string // --force-with-lease <ref>:<expected>
 }
 
+// CmdImport expects the helper to write a fast-import stream to Stream
+// (or directly to Writer).
 type CmdImport struct {
 	Config Config
 	Names  []string
+	Stream *fastimport.Writer
+
+	// Bidi queries fast-import for previously imported objects. It is nil
+	// unless the bidi-import capability was advertised.
+	Bidi *fastimport.Bidi
+
 	io.Reader
 	io.Writer
 }
 
+// CmdExport passes the fast-export stream of Git on Reader. Use
+// fastimport.NewReader to parse the stream up to its done command.
 type CmdExport struct {
 	Config Config
 	io.Reader
 	io.Writer
 }
 
+// CmdConnect hands the raw connection to the helper. Use the pktline
+// package to speak the Git wire protocol over Reader and Writer. The
+// connection is reported as established on first use; return ErrFallback
+// before touching it to let Git fall back to fetch and push.
 type CmdConnect struct {
 	Config  Config
 	Service string
@@ -62,22 +139,33 @@ type CmdConnect struct {
 	io.Writer
 }
 
-func (c *CmdUnknown) setConfig(config Config)      { c.Config = config }
-func (c *CmdCapabilities) setConfig(config Config) { c.Config = config }
-func (c *CmdList) setConfig(config Config)         { c.Config = config }
-func (c *CmdOption) setConfig(config Config)       { c.Config = config }
-func (c *CmdFetch) setConfig(config Config)        { c.Config = config }
-func (c *CmdPush) setConfig(config Config)         { c.Config = config }
-func (c *CmdImport) setConfig(config Config)       { c.Config = config }
-func (c *CmdExport) setConfig(config Config)       { c.Config = config }
-func (c *CmdConnect) setConfig(config Config)      { c.Config = config }
+type CmdStatelessConnect struct {
+	Config  Config
+	Service string
+}
+
+func (c *CmdUnknown) setConfig(config Config)          { c.Config = config }
+func (c *CmdCapabilities) setConfig(config Config)     { c.Config = config }
+func (c *CmdList) setConfig(config Config)             { c.Config = config }
+func (c *CmdOption) setConfig(config Config)           { c.Config = config }
+func (c *CmdFetch) setConfig(config Config)            { c.Config = config }
+func (c *CmdPush) setConfig(config Config)             { c.Config = config }
+func (c *CmdImport) setConfig(config Config)           { c.Config = config }
+func (c *CmdExport) setConfig(config Config)           { c.Config = config }
+func (c *CmdConnect) setConfig(config Config)          { c.Config = config }
+func (c *CmdStatelessConnect) setConfig(config Config) { c.Config = config }
 
 func (c *CmdUnknown) runCommand(r *runner, ctx context.Context) error {
-	return r.Helper.Unknown(ctx, c)
+	err := r.Helper.Unknown(ctx, c)
+	if err == ErrUnsupportedCommand {
+		return ErrInvalidCommand(c.Line)
+	}
+	return err
 }
 
 func (c *CmdCapabilities) runCommand(r *runner, ctx context.Context) error {
 	caps := r.Helper.Capabilities()
+	r.caps = caps
 	return caps.writeTo(r.bw)
 }
 
@@ -87,52 +175,288 @@ func (c *CmdList) runCommand(r *runner, ctx context.Context) error {
 		return err
 	}
 
+	for _, ref := range refs {
+		if ref.Hash != "" && !ref.Unknown {
+			err = r.ObjectFormat.checkHash(ref.Hash)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	keywords := c.Keywords
+	if r.Options.ObjectFormat != "" && r.caps.has(CapObjectFormat) && !c.hasKeyword("object-format") {
+		kw := ListKeyword{Name: "object-format", Value: string(r.ObjectFormat)}
+		keywords = append([]ListKeyword{kw}, keywords...)
+	}
+
 	l := listRefSlice(refs)
-	return l.writeTo(r.bw)
+	err = l.validate(keywords)
+	if err != nil {
+		return err
+	}
+
+	return l.writeTo(r.bw, keywords)
 }
 
 func (c *CmdOption) runCommand(r *runner, ctx context.Context) error {
-	err := r.Helper.SetOption(c.Key, c.Value)
+	var (
+		opts  = r.Options
+		known = true
+	)
+
+	err := opts.Set(c.Key, c.Value)
+	if err == nil && c.Key == "object-format" {
+		err = r.negotiateObjectFormat(opts.ObjectFormat)
+	}
 	if err == ErrUnsupportedOption {
-		_, err = r.bw.WriteString("unsupported\n")
+		known = false
+	} else if err != nil {
+		_, err = r.bw.WriteString("error " + err.Error() + "\n")
 		return err
 	}
+
+	err = r.Helper.SetOption(c.Key, c.Value)
+	if err == ErrUnsupportedOption || err == ErrUnsupportedCommand {
+		if known && runnerOption(c.Key) {
+			// the runner acts on the option itself
+			err = nil
+		} else {
+			_, err = r.bw.WriteString("unsupported\n")
+			return err
+		}
+	}
 	if err != nil {
 		_, err = r.bw.WriteString("error " + err.Error() + "\n")
 		return err
 	}
+
+	if known {
+		r.Options = opts
+	}
 	_, err = r.bw.WriteString("ok\n")
 	return err
 }
 
 func (c *CmdFetch) runCommand(r *runner, ctx context.Context) error {
-	err := r.Helper.Fetch(ctx, c)
+	for _, ref := range c.Refs {
+		err := r.ObjectFormat.checkHash(ref.Hash)
+		if err != nil {
+			return err
+		}
+	}
+
+	res, err := r.Helper.Fetch(ctx, c)
 	if err != nil {
 		return err
 	}
 
+	for _, file := range res.LockFiles {
+		_, err = r.bw.WriteString("lock " + file + "\n")
+		if err != nil {
+			return err
+		}
+	}
+
+	if res.ConnectivityOK && r.Options.CheckConnectivity {
+		_, err = r.bw.WriteString("connectivity-ok\n")
+		if err != nil {
+			return err
+		}
+	}
+
 	_, err = r.bw.WriteRune('\n')
 	return err
 }
 
 func (c *CmdPush) runCommand(r *runner, ctx context.Context) error {
+	c.Options = PushOptions{
+		ServerOptions: r.Options.PushOptions,
+		Atomic:        r.Options.Atomic,
+		DryRun:        r.Options.DryRun,
+		Force:         r.Options.Force,
+		CAS:           r.Options.CAS,
+	}
+
+	attachExpectations(c.Refs, c.Options.CAS, r.ObjectFormat)
+
+	for _, ref := range c.Refs {
+		// src is an object id when Git has no local ref for it
+		if looksLikeHash(ref.Src) {
+			err := r.ObjectFormat.checkHash(ref.Src)
+			if err != nil {
+				return err
+			}
+		}
+		if ref.Expect != "" {
+			err := r.ObjectFormat.checkHash(ref.Expect)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
 	err := r.Helper.Push(ctx, c)
-	if err != nil {
+	if err == ErrUnsupportedCommand {
+		for _, ref := range c.Refs {
+			ref.SetStatus(PushRemoteError, err)
+		}
+	} else if err != nil {
 		return err
 	}
 
+	if c.Options.Atomic {
+		rejectAllUnlessOk(c.Refs)
+	}
+
 	s := pushRefSlice(c.Refs)
 	return s.writeTo(r.bw)
 }
 
 func (c *CmdImport) runCommand(r *runner, ctx context.Context) error {
-	return r.Helper.Import(ctx, c)
+	c.Stream = fastimport.NewWriter(r.bw)
+	if r.caps.has(CapBidiImport) {
+		c.Bidi = fastimport.NewBidi(c.Stream, r.br)
+	}
+	if r.Marks != nil {
+		c.Stream.SetLastMark(r.Marks.Last())
+	}
+
+	err := r.Helper.Import(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	return r.saveMarks()
 }
 
 func (c *CmdExport) runCommand(r *runner, ctx context.Context) error {
-	return r.Helper.Export(ctx, c)
+	err := r.Helper.Export(ctx, c)
+	if err == ErrUnsupportedCommand {
+		return rejectExport(r, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	// git fast-export wrote its marks before it sent done
+	if r.Marks != nil {
+		err = r.Marks.Reload()
+		if err != nil {
+			return err
+		}
+	}
+
+	return r.saveMarks()
 }
 
 func (c *CmdConnect) runCommand(r *runner, ctx context.Context) error {
-	return r.Helper.Connect(ctx, c)
+	stream := &connectStream{r: r}
+	c.Reader = stream
+	c.Writer = stream
+
+	err := r.Helper.Connect(ctx, c)
+	if err == ErrFallback || err == ErrUnsupportedCommand {
+		if stream.established {
+			return ErrLateFallback
+		}
+		_, err = r.bw.WriteString("fallback\n")
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	return stream.establish()
+}
+
+func (c *CmdStatelessConnect) runCommand(r *runner, ctx context.Context) error {
+	conn, err := r.Helper.StatelessConnect(ctx, c)
+	if err == ErrFallback || err == ErrUnsupportedCommand {
+		_, err = r.bw.WriteString("fallback\n")
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	_, err = r.bw.WriteRune('\n')
+	if err != nil {
+		return err
+	}
+
+	err = conn.Advertisement(ctx, r.bw)
+	if err != nil {
+		return err
+	}
+
+	err = r.bw.Flush()
+	if err != nil {
+		return err
+	}
+
+	var (
+		pr = pktline.NewReader(r.br)
+		pw = pktline.NewWriter(r.bw)
+	)
+
+	for {
+		req, err := readStatelessRequest(pr)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		err = conn.RoundTrip(ctx, bytes.NewReader(req), r.bw)
+		if err != nil {
+			return err
+		}
+
+		err = pw.WriteResponseEnd()
+		if err != nil {
+			return err
+		}
+
+		err = r.bw.Flush()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readStatelessRequest reads a single request of a stateless-connect
+// session. The returned bytes include the terminating flush-pkt.
+func readStatelessRequest(pr *pktline.Reader) ([]byte, error) {
+	var (
+		buf bytes.Buffer
+		pw  = pktline.NewWriter(&buf)
+	)
+
+	for {
+		pkt, err := pr.ReadPacket()
+		if err == io.EOF && buf.Len() > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch pkt.Type {
+		case pktline.Data:
+			err = pw.WritePacket(pkt.Payload)
+		default:
+			err = pw.WriteSpecial(pkt.Type)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if pkt.Type == pktline.Flush {
+			return buf.Bytes(), nil
+		}
+	}
 }
@@ -0,0 +1,54 @@
+package gitremote
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrLateFallback is returned when a helper asks for a fallback after it
+// already used the connection.
+var ErrLateFallback = errors.New("connect: fallback after the connection was established")
+
+// connectStream is the connection handed to Helper.Connect. The first Read
+// or Write tells Git the connection is established; writes are flushed
+// right away so Git sees every packet the helper sends.
+type connectStream struct {
+	r           *runner
+	established bool
+}
+
+func (s *connectStream) establish() error {
+	if s.established {
+		return nil
+	}
+	s.established = true
+
+	_, err := s.r.bw.WriteRune('\n')
+	if err != nil {
+		return err
+	}
+	return s.r.bw.Flush()
+}
+
+func (s *connectStream) Read(p []byte) (int, error) {
+	err := s.establish()
+	if err != nil {
+		return 0, err
+	}
+	return s.r.br.Read(p)
+}
+
+func (s *connectStream) Write(p []byte) (int, error) {
+	err := s.establish()
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := s.r.bw.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, s.r.bw.Flush()
+}
+
+var _ io.ReadWriter = (*connectStream)(nil)
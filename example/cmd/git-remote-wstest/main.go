@@ -22,46 +22,44 @@ import (
 )
 
 func main() {
-	conf := gitremote.DefaultConfig()
+	gitremote.MainFunc(newHelper)
+}
 
-	fmt.Fprintf(os.Stderr, "config: %v\n", conf)
+func newHelper(conf gitremote.Config) (gitremote.Helper, error) {
+	conf.Log.Debugf("remote %s at %s", conf.Remote, conf.URL)
 
 	u, err := url.Parse(conf.URL)
-	assert(err)
+	if err != nil {
+		return nil, err
+	}
 
 	repo, err := git.OpenRepository(conf.Dir)
-	assert(err)
+	if err != nil {
+		return nil, err
+	}
 
 	repoName := strings.TrimPrefix(strings.TrimSuffix(u.Path, ".git"), "/")
 	u.Path = "/"
 	u.Scheme = "ws"
 
 	r := mux.NewRouter()
-	r.HandleFunc("/objects/{hash}", objectHandler(repo)).Methods("GET")
+	r.HandleFunc("/objects/{hash}", objectHandler(repo, conf.Log)).Methods("GET")
 
 	peer, err := peernet.Dial(u.String(), r)
-	assert(err)
-
-	conf.Helper = &Helper{peer: peer, repoName: repoName, repo: repo}
-
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	err = gitremote.Run(ctx, conf)
-	assert(err)
-}
-
-func assert(err error) {
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: %s\n", err)
-		os.Exit(1)
+		return nil, err
 	}
+
+	return &Helper{peer: peer, repoName: repoName, repo: repo, log: conf.Log}, nil
 }
 
 type Helper struct {
+	gitremote.BaseHelper
+
 	peer     *peernet.Peer
 	repoName string
 	repo     *git.Repository
+	log      *gitremote.Logger
 
 	mtx         sync.Mutex
 	loaderCache map[string]bool
@@ -75,7 +73,7 @@ func (h *Helper) Capabilities() gitremote.Capabilities {
 }
 
 func (h *Helper) SetOption(key, value string) error {
-	fmt.Fprintf(os.Stderr, "option %q %q\n", key, value)
+	h.log.Tracef("option %q %q", key, value)
 	return nil
 }
 
@@ -124,18 +122,35 @@ func (h *Helper) List(ctx context.Context, cmd *gitremote.CmdList) ([]gitremote.
 		})
 	}
 
+	// start loading master while Git works out what it wants
+	if hash := body["refs/heads/master"]; hasMaster && !cmd.ForPush {
+		log := cmd.Config.Log
+		cmd.Config.Background.Go("prefetch", func(ctx context.Context) error {
+			return <-h.loadObject(hash, log, nil)
+		})
+	}
+
 	return refs, nil
 }
 
-func (h *Helper) Fetch(ctx context.Context, cmd *gitremote.CmdFetch) error {
-	for hash, _ := range cmd.Objects {
-		err := <-h.loadObject(hash)
+func (h *Helper) Fetch(ctx context.Context, cmd *gitremote.CmdFetch) (gitremote.FetchResult, error) {
+	log := cmd.Config.Log
+	received := cmd.Config.Progress.Start("Receiving objects", 0)
+	defer received.Done()
+
+	err := cmd.Config.Background.Wait(ctx, "prefetch")
+	if err != nil {
+		return gitremote.FetchResult{}, err
+	}
+
+	for _, ref := range cmd.Refs {
+		err = <-h.loadObject(ref.Hash, log, received)
 		if err != nil {
-			return err
+			return gitremote.FetchResult{}, err
 		}
 	}
 
-	return nil
+	return gitremote.FetchResult{}, nil
 }
 
 func (h *Helper) Push(ctx context.Context, cmd *gitremote.CmdPush) error {
@@ -194,10 +209,15 @@ func (h *Helper) Push(ctx context.Context, cmd *gitremote.CmdPush) error {
 	for _, op := range ops {
 		for _, ref := range cmd.Refs {
 			if ref.Dst == op.Name {
-				ref.Ok = op.Ok
-
-				if op.Err != "" {
-					ref.Err = fmt.Errorf(op.Err)
+				switch {
+				case op.Ok && ref.Src == "":
+					ref.SetStatus(gitremote.PushDeleted, nil)
+				case op.Ok:
+					ref.SetStatus(gitremote.PushOk, nil)
+				case op.Err == "not fast-forward":
+					ref.SetStatus(gitremote.PushRejectedNonFastForward, nil)
+				default:
+					ref.SetStatus(gitremote.PushRemoteError, fmt.Errorf(op.Err))
 				}
 
 				break
@@ -208,23 +228,7 @@ func (h *Helper) Push(ctx context.Context, cmd *gitremote.CmdPush) error {
 	return nil
 }
 
-func (h *Helper) Export(ctx context.Context, cmd *gitremote.CmdExport) error {
-	panic("unsupported command: Export")
-}
-
-func (h *Helper) Import(ctx context.Context, cmd *gitremote.CmdImport) error {
-	panic("unsupported command: Import")
-}
-
-func (h *Helper) Connect(ctx context.Context, cmd *gitremote.CmdConnect) error {
-	panic("unsupported command: Connect")
-}
-
-func (h *Helper) Unknown(ctx context.Context, cmd *gitremote.CmdUnknown) error {
-	panic(fmt.Sprintf("unsupported command: %q", cmd.Line))
-}
-
-func objectHandler(repo *git.Repository) http.HandlerFunc {
+func objectHandler(repo *git.Repository, log *gitremote.Logger) http.HandlerFunc {
 	return func(rw http.ResponseWriter, req *http.Request) {
 		var (
 			vars = mux.Vars(req)
@@ -243,7 +247,7 @@ func objectHandler(repo *git.Repository) http.HandlerFunc {
 
 		defer rc.Close()
 
-		fmt.Fprintf(os.Stderr, "sending %s %q %d\n", typ, hash, length)
+		log.Debugf("sending %s %q %d", typ, hash, length)
 
 		header := fmt.Sprintf("%s %d\x00", strings.ToLower(typ.String()), length)
 
@@ -262,7 +266,7 @@ func objectHandler(repo *git.Repository) http.HandlerFunc {
 	}
 }
 
-func (h *Helper) loadObject(hash string) <-chan error {
+func (h *Helper) loadObject(hash string, log *gitremote.Logger, received *gitremote.Meter) <-chan error {
 	out := make(chan error, 1)
 	go func() {
 		defer close(out)
@@ -315,21 +319,21 @@ func (h *Helper) loadObject(hash string) <-chan error {
 		w := zlib.NewWriter(f)
 		_, err = io.Copy(w, resp.Body)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "HERE H: %s\n", err)
+			log.Debugf("load %s: %s", hash, err)
 			out <- err
 			return
 		}
 
 		err = w.Close()
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "HERE G: %s\n", err)
+			log.Debugf("load %s: %s", hash, err)
 			out <- err
 			return
 		}
 
 		err = f.Close()
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "HERE F: %s\n", err)
+			log.Debugf("load %s: %s", hash, err)
 			out <- err
 			return
 		}
@@ -338,7 +342,7 @@ func (h *Helper) loadObject(hash string) <-chan error {
 
 		typ, _, _, err := h.repo.GetRawObject(hash, true)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "HERE E: %s (%s)\n", err, typ)
+			log.Debugf("load %s: %s (%s)", hash, err, typ)
 			out <- err
 			return
 		}
@@ -348,44 +352,44 @@ func (h *Helper) loadObject(hash string) <-chan error {
 		case git.ObjectCommit:
 			c, err := h.repo.GetCommit(hash)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "HERE D: %s\n", err)
+				log.Debugf("load %s: %s", hash, err)
 				out <- err
 				return
 			}
 
-			q = append(q, h.loadObject(c.TreeId().String()))
+			q = append(q, h.loadObject(c.TreeId().String(), log, received))
 			for i, l := 0, c.ParentCount(); i < l; i++ {
 				id, err := c.ParentId(i)
 				if err != nil {
-					fmt.Fprintf(os.Stderr, "HERE C: %s\n", err)
+					log.Debugf("load %s: %s", hash, err)
 					out <- err
 					return
 				}
 
-				q = append(q, h.loadObject(id.String()))
+				q = append(q, h.loadObject(id.String(), log, received))
 			}
 
 		case git.ObjectTree:
 			t, err := h.repo.GetTree(hash)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "HERE B: %s\n", err)
+				log.Debugf("load %s: %s", hash, err)
 				out <- err
 				return
 			}
 
 			for _, e := range t.ListEntries() {
-				q = append(q, h.loadObject(e.Id.String()))
+				q = append(q, h.loadObject(e.Id.String(), log, received))
 			}
 
 		case git.ObjectTag:
 			t, err := h.repo.GetTagWithId(hash)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "HERE A: %s\n", err)
+				log.Debugf("load %s: %s", hash, err)
 				out <- err
 				return
 			}
 
-			q = append(q, h.loadObject(t.Object.String()))
+			q = append(q, h.loadObject(t.Object.String(), log, received))
 
 		}
 
@@ -398,7 +402,7 @@ func (h *Helper) loadObject(hash string) <-chan error {
 			}
 		}
 
-		fmt.Fprintf(os.Stderr, "Loaded: %q\n", hash)
+		received.Add(1)
 	}()
 	return out
 }
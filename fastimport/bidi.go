@@ -0,0 +1,154 @@
+package fastimport
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrMissing is returned by Bidi when fast-import does not know the
+// requested object or path.
+var ErrMissing = errors.New("fastimport: missing object")
+
+// Bidi queries fast-import while a stream is being written. Requests are
+// written with the stream Writer and the responses are read from the
+// cat-blob channel (the stdin of the remote helper when bidi-import is
+// enabled). Calls from several goroutines are serialised: each waits for
+// the response to its request before the next request is written.
+type Bidi struct {
+	mtx sync.Mutex
+	w   *Writer
+	br  *bufio.Reader
+}
+
+// NewBidi returns a Bidi which writes requests to w and reads responses
+// from r. When r is a *bufio.Reader it is used directly.
+func NewBidi(w *Writer, r io.Reader) *Bidi {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &Bidi{w: w, br: br}
+}
+
+// LsEntry is the response to an ls request.
+type LsEntry struct {
+	Mode    Mode
+	Type    string // blob, tree or commit
+	DataRef string
+	Path    string
+}
+
+// CatBlob returns the object id and contents of the blob dataref refers to.
+func (b *Bidi) CatBlob(dataref string) (string, []byte, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	err := b.w.CatBlob(dataref)
+	if err != nil {
+		return "", nil, err
+	}
+
+	line, err := b.readLine()
+	if err != nil {
+		return "", nil, err
+	}
+
+	parts := strings.Split(line, " ")
+	if len(parts) == 2 && parts[1] == "missing" {
+		return parts[0], nil, ErrMissing
+	}
+	if len(parts) != 3 || parts[1] != "blob" {
+		return "", nil, ErrUnexpectedLine(line)
+	}
+
+	size, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil || size < 0 {
+		return "", nil, ErrUnexpectedLine(line)
+	}
+
+	data := make([]byte, size+1)
+	_, err = io.ReadFull(b.br, data)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	if data[size] != '\n' {
+		return "", nil, fmt.Errorf("fastimport: missing LF after cat-blob response")
+	}
+
+	return parts[0], data[:size], nil
+}
+
+// Ls returns the entry at path in the tree of dataref. When dataref is
+// empty the tree of the commit currently being written is used.
+func (b *Bidi) Ls(dataref, path string) (LsEntry, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	err := b.w.Ls(dataref, path)
+	if err != nil {
+		return LsEntry{}, err
+	}
+
+	line, err := b.readLine()
+	if err != nil {
+		return LsEntry{}, err
+	}
+
+	if strings.HasPrefix(line, "missing ") {
+		return LsEntry{}, ErrMissing
+	}
+
+	tab := strings.IndexByte(line, '\t')
+	if tab < 0 {
+		return LsEntry{}, ErrUnexpectedLine(line)
+	}
+
+	parts := strings.Split(line[:tab], " ")
+	if len(parts) != 3 {
+		return LsEntry{}, ErrUnexpectedLine(line)
+	}
+
+	mode, err := strconv.ParseUint(parts[0], 8, 32)
+	if err != nil {
+		return LsEntry{}, ErrUnexpectedLine(line)
+	}
+
+	p, err := UnquotePath(line[tab+1:])
+	if err != nil {
+		return LsEntry{}, err
+	}
+
+	return LsEntry{Mode: Mode(mode), Type: parts[1], DataRef: parts[2], Path: p}, nil
+}
+
+// GetMark returns the object id of mark.
+func (b *Bidi) GetMark(mark Mark) (string, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	err := b.w.GetMark(mark)
+	if err != nil {
+		return "", err
+	}
+
+	return b.readLine()
+}
+
+func (b *Bidi) readLine() (string, error) {
+	line, err := b.br.ReadString('\n')
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(line, "\n"), nil
+}
@@ -0,0 +1,130 @@
+package fastimport
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// QuotePath quotes path the way fast-import expects it. Paths are C-style
+// quoted when they start with a double quote or contain a newline. When
+// space is true paths containing spaces are quoted as well; this is
+// required for the source path of copy and rename operations.
+func QuotePath(path string, space bool) string {
+	if !needsQuoting(path, space) {
+		return path
+	}
+	return quotePath(path)
+}
+
+// quotePath C-style quotes path unconditionally.
+func quotePath(path string) string {
+	var b strings.Builder
+
+	b.WriteByte('"')
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		switch c {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			if c < 0x20 || c == 0x7f {
+				fmt.Fprintf(&b, `\%03o`, c)
+			} else {
+				b.WriteByte(c)
+			}
+		}
+	}
+	b.WriteByte('"')
+
+	return b.String()
+}
+
+func needsQuoting(path string, space bool) bool {
+	if strings.HasPrefix(path, `"`) {
+		return true
+	}
+
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if c == '\n' || c < 0x20 || c == 0x7f {
+			return true
+		}
+		if space && c == ' ' {
+			return true
+		}
+	}
+
+	return false
+}
+
+// UnquotePath decodes a C-style quoted path. Paths which do not start with
+// a double quote are returned as is.
+func UnquotePath(path string) (string, error) {
+	if !strings.HasPrefix(path, `"`) {
+		return path, nil
+	}
+
+	if len(path) < 2 || !strings.HasSuffix(path, `"`) {
+		return "", fmt.Errorf("fastimport: invalid quoted path: %q", path)
+	}
+
+	var (
+		b   strings.Builder
+		src = path[1 : len(path)-1]
+	)
+
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		if c != '\\' {
+			b.WriteByte(c)
+			continue
+		}
+
+		i++
+		if i == len(src) {
+			return "", fmt.Errorf("fastimport: invalid quoted path: %q", path)
+		}
+
+		switch c = src[i]; c {
+		case 'a':
+			b.WriteByte('\a')
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'v':
+			b.WriteByte('\v')
+		case '\\', '"':
+			b.WriteByte(c)
+		case '0', '1', '2', '3':
+			if i+3 > len(src) {
+				return "", fmt.Errorf("fastimport: invalid quoted path: %q", path)
+			}
+			n, err := strconv.ParseUint(src[i:i+3], 8, 8)
+			if err != nil {
+				return "", fmt.Errorf("fastimport: invalid quoted path: %q", path)
+			}
+			b.WriteByte(byte(n))
+			i += 2
+		default:
+			return "", fmt.Errorf("fastimport: invalid quoted path: %q", path)
+		}
+	}
+
+	return b.String(), nil
+}
@@ -0,0 +1,41 @@
+package fastimport
+
+import "testing"
+
+func TestQuotePath(t *testing.T) {
+	tests := []struct {
+		path  string
+		space bool
+		want  string
+	}{
+		{"plain/path", false, "plain/path"},
+		{"with space", false, "with space"},
+		{"with space", true, `"with space"`},
+		{`"leading quote`, false, `"\"leading quote"`},
+		{"new\nline", false, `"new\nline"`},
+		{"tab\there", false, `"tab\there"`},
+		{"back\\slash\x01", false, `"back\\slash\001"`},
+		{"ünïcode", false, "ünïcode"},
+	}
+
+	for _, test := range tests {
+		got := QuotePath(test.path, test.space)
+		if got != test.want {
+			t.Errorf("QuotePath(%q, %v) = %q, want %q", test.path, test.space, got, test.want)
+		}
+
+		back, err := UnquotePath(got)
+		if err != nil || back != test.path {
+			t.Errorf("UnquotePath(%q) = %q, %v, want %q", got, back, err, test.path)
+		}
+	}
+}
+
+func TestUnquotePathInvalid(t *testing.T) {
+	for _, s := range []string{`"`, `"unterminated`, `"bad \q escape"`} {
+		_, err := UnquotePath(s)
+		if err == nil {
+			t.Errorf("UnquotePath(%q) should fail", s)
+		}
+	}
+}
@@ -0,0 +1,559 @@
+package fastimport
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+type ErrUnexpectedLine string
+
+func (e ErrUnexpectedLine) Error() string {
+	return fmt.Sprintf("fastimport: unexpected line: %q", string(e))
+}
+
+// Reader parses a fast-export stream into commands. Marks defined in the
+// stream are recorded so that datarefs and commit-ishes can be resolved
+// with Resolve.
+type Reader struct {
+	br      *bufio.Reader
+	pending *string
+	done    bool
+	useDone bool
+	marks   map[Mark]Command
+}
+
+// NewReader returns a reader for r. When r is a *bufio.Reader it is used
+// directly so that no data past the done command is consumed.
+func NewReader(r io.Reader) *Reader {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+
+	return &Reader{
+		br:    br,
+		marks: make(map[Mark]Command),
+	}
+}
+
+// Resolve returns the *Blob or *Commit a mark reference (":<n>") refers to.
+// Aliases are followed. It returns false for object ids and for marks which
+// were not defined in this stream.
+func (r *Reader) Resolve(ref string) (Command, bool) {
+	for i := 0; i < 8; i++ {
+		mark, err := ParseMark(ref)
+		if err != nil {
+			return nil, false
+		}
+
+		cmd, ok := r.marks[mark]
+		if !ok {
+			return nil, false
+		}
+
+		alias, ok := cmd.(*Alias)
+		if !ok {
+			return cmd, true
+		}
+
+		ref = alias.To
+	}
+
+	return nil, false
+}
+
+// Next returns the next command in the stream. After the done command, or
+// at the end of a stream which did not request the done feature, io.EOF
+// is returned.
+func (r *Reader) Next() (Command, error) {
+	if r.done {
+		return nil, io.EOF
+	}
+
+	for {
+		line, err := r.readLine()
+		if err == io.EOF && r.useDone {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+
+		case line == "", strings.HasPrefix(line, "#"):
+			continue
+
+		case line == "blob":
+			return r.readBlob()
+
+		case strings.HasPrefix(line, "commit "):
+			return r.readCommit(strings.TrimPrefix(line, "commit "))
+
+		case strings.HasPrefix(line, "tag "):
+			return r.readTag(strings.TrimPrefix(line, "tag "))
+
+		case strings.HasPrefix(line, "reset "):
+			return r.readReset(strings.TrimPrefix(line, "reset "))
+
+		case line == "alias":
+			return r.readAlias()
+
+		case strings.HasPrefix(line, "feature "):
+			f := &Feature{Name: strings.TrimPrefix(line, "feature ")}
+			if idx := strings.IndexByte(f.Name, '='); idx >= 0 {
+				f.Name, f.Arg = f.Name[:idx], f.Name[idx+1:]
+			}
+			if f.Name == "done" {
+				r.useDone = true
+			}
+			return f, nil
+
+		case strings.HasPrefix(line, "option "):
+			return &Option{Option: strings.TrimPrefix(line, "option ")}, nil
+
+		case strings.HasPrefix(line, "progress "):
+			return &Progress{Message: strings.TrimPrefix(line, "progress ")}, nil
+
+		case line == "checkpoint":
+			return &Checkpoint{}, nil
+
+		case line == "done":
+			r.done = true
+			return &Done{}, nil
+
+		default:
+			return nil, ErrUnexpectedLine(line)
+
+		}
+	}
+}
+
+func (r *Reader) readLine() (string, error) {
+	if r.pending != nil {
+		line := *r.pending
+		r.pending = nil
+		return line, nil
+	}
+
+	line, err := r.br.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(line, "\n"), nil
+}
+
+func (r *Reader) unreadLine(line string) {
+	r.pending = &line
+}
+
+// readOptional reads the next line and returns its value when it starts
+// with prefix. Otherwise the line is pushed back.
+func (r *Reader) readOptional(prefix string) (string, bool, error) {
+	line, err := r.readLine()
+	if err == io.EOF {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	if !strings.HasPrefix(line, prefix) {
+		r.unreadLine(line)
+		return "", false, nil
+	}
+
+	return strings.TrimPrefix(line, prefix), true, nil
+}
+
+func (r *Reader) readMark() (Mark, error) {
+	s, ok, err := r.readOptional("mark ")
+	if err != nil || !ok {
+		return 0, err
+	}
+	return ParseMark(s)
+}
+
+func (r *Reader) readData() ([]byte, error) {
+	line, err := r.readLine()
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "data ") {
+		return nil, ErrUnexpectedLine(line)
+	}
+
+	arg := strings.TrimPrefix(line, "data ")
+
+	// delimited format
+	if strings.HasPrefix(arg, "<<") {
+		var (
+			delim = arg[2:]
+			data  []byte
+		)
+		for {
+			line, err := r.readLine()
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			if err != nil {
+				return nil, err
+			}
+			if line == delim {
+				return data, nil
+			}
+			data = append(data, line...)
+			data = append(data, '\n')
+		}
+	}
+
+	// exact byte count format
+	size, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || size < 0 {
+		return nil, ErrUnexpectedLine(line)
+	}
+
+	data := make([]byte, size)
+	_, err = io.ReadFull(r.br, data)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// skip the optional LF after the data
+	if next, err := r.br.Peek(1); err == nil && next[0] == '\n' {
+		r.br.ReadByte()
+	}
+
+	return data, nil
+}
+
+func (r *Reader) readBlob() (Command, error) {
+	var (
+		b   = &Blob{}
+		err error
+	)
+
+	b.Mark, err = r.readMark()
+	if err != nil {
+		return nil, err
+	}
+
+	b.OriginalOID, _, err = r.readOptional("original-oid ")
+	if err != nil {
+		return nil, err
+	}
+
+	b.Data, err = r.readData()
+	if err != nil {
+		return nil, err
+	}
+
+	if b.Mark != 0 {
+		r.marks[b.Mark] = b
+	}
+
+	return b, nil
+}
+
+func (r *Reader) readIdent(prefix string) (*Ident, error) {
+	s, ok, err := r.readOptional(prefix)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	ident, err := ParseIdent(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ident, nil
+}
+
+func (r *Reader) readCommit(ref string) (Command, error) {
+	var (
+		c   = &Commit{Ref: ref}
+		err error
+	)
+
+	c.Mark, err = r.readMark()
+	if err != nil {
+		return nil, err
+	}
+
+	c.OriginalOID, _, err = r.readOptional("original-oid ")
+	if err != nil {
+		return nil, err
+	}
+
+	c.Author, err = r.readIdent("author ")
+	if err != nil {
+		return nil, err
+	}
+
+	committer, err := r.readIdent("committer ")
+	if err != nil {
+		return nil, err
+	}
+	if committer == nil {
+		line, _ := r.readLine()
+		return nil, ErrUnexpectedLine(line)
+	}
+	c.Committer = *committer
+
+	c.Encoding, _, err = r.readOptional("encoding ")
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := r.readData()
+	if err != nil {
+		return nil, err
+	}
+	c.Message = string(msg)
+
+	c.From, _, err = r.readOptional("from ")
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		merge, ok, err := r.readOptional("merge ")
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		c.Merge = append(c.Merge, merge)
+	}
+
+	for {
+		op, err := r.readFileOp()
+		if err != nil {
+			return nil, err
+		}
+		if op == nil {
+			break
+		}
+		c.Ops = append(c.Ops, op)
+	}
+
+	if c.Mark != 0 {
+		r.marks[c.Mark] = c
+	}
+
+	return c, nil
+}
+
+// readFileOp returns nil at the end of the file operations of a commit.
+func (r *Reader) readFileOp() (FileOp, error) {
+	line, err := r.readLine()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+
+	case line == "":
+		return nil, nil
+
+	case line == "deleteall":
+		return FileDeleteAll{}, nil
+
+	case strings.HasPrefix(line, "M "):
+		parts := strings.SplitN(strings.TrimPrefix(line, "M "), " ", 3)
+		if len(parts) != 3 {
+			return nil, ErrUnexpectedLine(line)
+		}
+
+		mode, err := strconv.ParseUint(parts[0], 8, 32)
+		if err != nil {
+			return nil, ErrUnexpectedLine(line)
+		}
+
+		path, err := UnquotePath(parts[2])
+		if err != nil {
+			return nil, err
+		}
+
+		op := FileModify{Mode: Mode(mode), Path: path}
+		if parts[1] == "inline" {
+			op.Data, err = r.readData()
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			op.DataRef = parts[1]
+		}
+
+		return op, nil
+
+	case strings.HasPrefix(line, "D "):
+		path, err := UnquotePath(strings.TrimPrefix(line, "D "))
+		if err != nil {
+			return nil, err
+		}
+		return FileDelete{Path: path}, nil
+
+	case strings.HasPrefix(line, "C "), strings.HasPrefix(line, "R "):
+		src, dst, err := splitPaths(line[2:])
+		if err != nil {
+			return nil, err
+		}
+		if line[0] == 'C' {
+			return FileCopy{Src: src, Dst: dst}, nil
+		}
+		return FileRename{Src: src, Dst: dst}, nil
+
+	case strings.HasPrefix(line, "N "):
+		parts := strings.SplitN(strings.TrimPrefix(line, "N "), " ", 2)
+		if len(parts) != 2 {
+			return nil, ErrUnexpectedLine(line)
+		}
+
+		op := NoteModify{Commit: parts[1]}
+		if parts[0] == "inline" {
+			op.Data, err = r.readData()
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			op.DataRef = parts[0]
+		}
+
+		return op, nil
+
+	default:
+		// the optional LF after a commit was omitted
+		r.unreadLine(line)
+		return nil, nil
+
+	}
+}
+
+// splitPaths splits the arguments of a copy or rename operation.
+func splitPaths(s string) (string, string, error) {
+	var end int
+
+	if strings.HasPrefix(s, `"`) {
+		end = -1
+		for i := 1; i < len(s); i++ {
+			if s[i] == '\\' {
+				i++
+				continue
+			}
+			if s[i] == '"' {
+				end = i + 1
+				break
+			}
+		}
+		if end < 0 {
+			return "", "", ErrUnexpectedLine(s)
+		}
+	} else {
+		end = strings.IndexByte(s, ' ')
+		if end < 0 {
+			return "", "", ErrUnexpectedLine(s)
+		}
+	}
+
+	if end >= len(s) || s[end] != ' ' {
+		return "", "", ErrUnexpectedLine(s)
+	}
+
+	src, err := UnquotePath(s[:end])
+	if err != nil {
+		return "", "", err
+	}
+
+	dst, err := UnquotePath(s[end+1:])
+	if err != nil {
+		return "", "", err
+	}
+
+	return src, dst, nil
+}
+
+func (r *Reader) readTag(name string) (Command, error) {
+	var (
+		t   = &Tag{Name: name}
+		err error
+	)
+
+	t.Mark, err = r.readMark()
+	if err != nil {
+		return nil, err
+	}
+
+	t.From, _, err = r.readOptional("from ")
+	if err != nil {
+		return nil, err
+	}
+
+	t.OriginalOID, _, err = r.readOptional("original-oid ")
+	if err != nil {
+		return nil, err
+	}
+
+	t.Tagger, err = r.readIdent("tagger ")
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := r.readData()
+	if err != nil {
+		return nil, err
+	}
+	t.Message = string(msg)
+
+	return t, nil
+}
+
+func (r *Reader) readReset(ref string) (Command, error) {
+	var (
+		reset = &Reset{Ref: ref}
+		err   error
+	)
+
+	reset.From, _, err = r.readOptional("from ")
+	if err != nil {
+		return nil, err
+	}
+
+	return reset, nil
+}
+
+func (r *Reader) readAlias() (Command, error) {
+	mark, err := r.readMark()
+	if err != nil {
+		return nil, err
+	}
+
+	to, ok, err := r.readOptional("to ")
+	if err != nil {
+		return nil, err
+	}
+	if mark == 0 || !ok {
+		return nil, ErrUnexpectedLine("alias")
+	}
+
+	a := &Alias{Mark: mark, To: to}
+	r.marks[mark] = a
+	return a, nil
+}
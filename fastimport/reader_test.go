@@ -0,0 +1,33 @@
+package fastimport
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestReaderUsesBufioReader(t *testing.T) {
+	// a buffer smaller than bufio's default must still be used as is
+	br := bufio.NewReaderSize(strings.NewReader("feature done\nprogress p\ndone\nafter\n"), 16)
+
+	r := NewReader(br)
+	for {
+		_, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rest, err := ioutil.ReadAll(br)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rest) != "after\n" {
+		t.Errorf("data after done: got %q, want %q", rest, "after\n")
+	}
+}
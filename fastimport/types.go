@@ -0,0 +1,221 @@
+// Package fastimport reads and writes git fast-import streams as used by
+// the import and export commands of remote helpers.
+package fastimport
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Mark identifies an object within a stream. The zero Mark means no mark.
+type Mark int
+
+func (m Mark) String() string {
+	return ":" + strconv.Itoa(int(m))
+}
+
+// ParseMark parses a mark reference like ":42".
+func ParseMark(s string) (Mark, error) {
+	if !strings.HasPrefix(s, ":") {
+		return 0, fmt.Errorf("fastimport: invalid mark: %q", s)
+	}
+
+	n, err := strconv.Atoi(s[1:])
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("fastimport: invalid mark: %q", s)
+	}
+
+	return Mark(n), nil
+}
+
+type Mode uint32
+
+const (
+	ModeFile       Mode = 0100644
+	ModeExecutable Mode = 0100755
+	ModeSymlink    Mode = 0120000
+	ModeGitlink    Mode = 0160000
+	ModeTree       Mode = 0040000
+)
+
+func (m Mode) String() string {
+	return fmt.Sprintf("%06o", uint32(m))
+}
+
+// Ident is an author, committer or tagger line.
+type Ident struct {
+	Name  string
+	Email string
+	When  time.Time
+}
+
+// ParseIdent parses the value of an author, committer or tagger line.
+func ParseIdent(s string) (Ident, error) {
+	lt := strings.IndexByte(s, '<')
+	gt := strings.LastIndexByte(s, '>')
+	if lt < 0 || gt < lt {
+		return Ident{}, fmt.Errorf("fastimport: invalid ident: %q", s)
+	}
+
+	var (
+		name  = strings.TrimSpace(s[:lt])
+		email = s[lt+1 : gt]
+		rest  = strings.Fields(s[gt+1:])
+	)
+
+	if len(rest) != 2 {
+		return Ident{}, fmt.Errorf("fastimport: invalid ident: %q", s)
+	}
+
+	sec, err := strconv.ParseInt(rest[0], 10, 64)
+	if err != nil {
+		return Ident{}, fmt.Errorf("fastimport: invalid ident: %q", s)
+	}
+
+	tz := rest[1]
+	if len(tz) != 5 || (tz[0] != '+' && tz[0] != '-') {
+		return Ident{}, fmt.Errorf("fastimport: invalid ident: %q", s)
+	}
+	hh, err1 := strconv.Atoi(tz[1:3])
+	mm, err2 := strconv.Atoi(tz[3:5])
+	if err1 != nil || err2 != nil {
+		return Ident{}, fmt.Errorf("fastimport: invalid ident: %q", s)
+	}
+	offset := hh*3600 + mm*60
+	if tz[0] == '-' {
+		offset = -offset
+	}
+
+	when := time.Unix(sec, 0).In(time.FixedZone("", offset))
+	return Ident{Name: name, Email: email, When: when}, nil
+}
+
+func (i Ident) String() string {
+	var name string
+	if i.Name != "" {
+		name = i.Name + " "
+	}
+	return fmt.Sprintf("%s<%s> %d %s", name, i.Email, i.When.Unix(), i.When.Format("-0700"))
+}
+
+// Command is a single command in a stream as returned by Reader.Next. It is
+// one of *Blob, *Commit, *Tag, *Reset, *Alias, *Feature, *Option,
+// *Progress, *Checkpoint or *Done.
+type Command interface {
+	command()
+}
+
+type Blob struct {
+	Mark        Mark
+	OriginalOID string
+	Data        []byte
+}
+
+type Commit struct {
+	Ref         string
+	Mark        Mark
+	OriginalOID string
+	Author      *Ident // defaults to the committer when nil
+	Committer   Ident
+	Encoding    string
+	Message     string
+	From        string   // commit-ish of the first parent
+	Merge       []string // commit-ishes of the other parents
+	Ops         []FileOp
+}
+
+type Tag struct {
+	Name        string
+	Mark        Mark
+	From        string
+	OriginalOID string
+	Tagger      *Ident
+	Message     string
+}
+
+type Reset struct {
+	Ref  string
+	From string
+}
+
+// Alias records that Mark refers to the commit-ish To.
+type Alias struct {
+	Mark Mark
+	To   string
+}
+
+type Feature struct {
+	Name string
+	Arg  string
+}
+
+type Option struct {
+	Option string
+}
+
+type Progress struct {
+	Message string
+}
+
+type Checkpoint struct{}
+
+type Done struct{}
+
+func (*Blob) command()       {}
+func (*Commit) command()     {}
+func (*Tag) command()        {}
+func (*Reset) command()      {}
+func (*Alias) command()      {}
+func (*Feature) command()    {}
+func (*Option) command()     {}
+func (*Progress) command()   {}
+func (*Checkpoint) command() {}
+func (*Done) command()       {}
+
+// FileOp is a change to the tree of a commit. It is one of FileModify,
+// FileDelete, FileCopy, FileRename, FileDeleteAll or NoteModify.
+type FileOp interface {
+	fileOp()
+}
+
+// FileModify adds or changes a file. When DataRef is empty Data is written
+// inline.
+type FileModify struct {
+	Mode    Mode
+	DataRef string
+	Data    []byte
+	Path    string
+}
+
+type FileDelete struct {
+	Path string
+}
+
+type FileCopy struct {
+	Src string
+	Dst string
+}
+
+type FileRename struct {
+	Src string
+	Dst string
+}
+
+type FileDeleteAll struct{}
+
+// NoteModify attaches a note to Commit. When DataRef is empty Data is
+// written inline.
+type NoteModify struct {
+	DataRef string
+	Data    []byte
+	Commit  string
+}
+
+func (FileModify) fileOp()    {}
+func (FileDelete) fileOp()    {}
+func (FileCopy) fileOp()      {}
+func (FileRename) fileOp()    {}
+func (FileDeleteAll) fileOp() {}
+func (NoteModify) fileOp()    {}
@@ -0,0 +1,257 @@
+package fastimport
+
+import (
+	"fmt"
+	"io"
+)
+
+type flusher interface {
+	Flush() error
+}
+
+// Writer writes a fast-import stream. The first error encountered is
+// sticky and returned by all following calls.
+type Writer struct {
+	w        io.Writer
+	lastMark Mark
+	err      error
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// NextMark allocates a new mark.
+func (w *Writer) NextMark() Mark {
+	w.lastMark++
+	return w.lastMark
+}
+
+// SetLastMark makes NextMark continue after mark. Use it when marks were
+// imported from a previous run.
+func (w *Writer) SetLastMark(mark Mark) {
+	if mark > w.lastMark {
+		w.lastMark = mark
+	}
+}
+
+func (w *Writer) Err() error {
+	return w.err
+}
+
+func (w *Writer) printf(format string, args ...interface{}) {
+	if w.err != nil {
+		return
+	}
+	_, w.err = fmt.Fprintf(w.w, format, args...)
+}
+
+func (w *Writer) write(p []byte) {
+	if w.err != nil {
+		return
+	}
+	_, w.err = w.w.Write(p)
+}
+
+func (w *Writer) mark(mark Mark) {
+	if mark != 0 {
+		w.printf("mark %s\n", mark)
+	}
+}
+
+func (w *Writer) originalOID(oid string) {
+	if oid != "" {
+		w.printf("original-oid %s\n", oid)
+	}
+}
+
+func (w *Writer) data(p []byte) {
+	w.printf("data %d\n", len(p))
+	w.write(p)
+	w.printf("\n")
+}
+
+func (w *Writer) Blob(b *Blob) error {
+	w.printf("blob\n")
+	w.mark(b.Mark)
+	w.originalOID(b.OriginalOID)
+	w.data(b.Data)
+	return w.err
+}
+
+// BlobFrom writes a blob of size bytes read from r without buffering it in
+// memory.
+func (w *Writer) BlobFrom(mark Mark, size int64, r io.Reader) error {
+	w.printf("blob\n")
+	w.mark(mark)
+	w.printf("data %d\n", size)
+	if w.err == nil {
+		var n int64
+		n, w.err = io.CopyN(w.w, r, size)
+		if w.err == io.EOF {
+			w.err = fmt.Errorf("fastimport: short blob: %d of %d bytes", n, size)
+		}
+	}
+	w.printf("\n")
+	return w.err
+}
+
+func (w *Writer) Commit(c *Commit) error {
+	w.printf("commit %s\n", c.Ref)
+	w.mark(c.Mark)
+	w.originalOID(c.OriginalOID)
+	if c.Author != nil {
+		w.printf("author %s\n", c.Author)
+	}
+	w.printf("committer %s\n", c.Committer)
+	if c.Encoding != "" {
+		w.printf("encoding %s\n", c.Encoding)
+	}
+	w.data([]byte(c.Message))
+	if c.From != "" {
+		w.printf("from %s\n", c.From)
+	}
+	for _, merge := range c.Merge {
+		w.printf("merge %s\n", merge)
+	}
+	for _, op := range c.Ops {
+		w.fileOp(op)
+	}
+	w.printf("\n")
+	return w.err
+}
+
+func (w *Writer) fileOp(op FileOp) {
+	switch op := op.(type) {
+
+	case FileModify:
+		if op.DataRef == "" {
+			w.printf("M %s inline %s\n", op.Mode, QuotePath(op.Path, false))
+			w.data(op.Data)
+		} else {
+			w.printf("M %s %s %s\n", op.Mode, op.DataRef, QuotePath(op.Path, false))
+		}
+
+	case FileDelete:
+		w.printf("D %s\n", QuotePath(op.Path, false))
+
+	case FileCopy:
+		w.printf("C %s %s\n", QuotePath(op.Src, true), QuotePath(op.Dst, false))
+
+	case FileRename:
+		w.printf("R %s %s\n", QuotePath(op.Src, true), QuotePath(op.Dst, false))
+
+	case FileDeleteAll:
+		w.printf("deleteall\n")
+
+	case NoteModify:
+		if op.DataRef == "" {
+			w.printf("N inline %s\n", op.Commit)
+			w.data(op.Data)
+		} else {
+			w.printf("N %s %s\n", op.DataRef, op.Commit)
+		}
+
+	default:
+		if w.err == nil {
+			w.err = fmt.Errorf("fastimport: unknown file operation %T", op)
+		}
+
+	}
+}
+
+func (w *Writer) Tag(t *Tag) error {
+	w.printf("tag %s\n", t.Name)
+	w.mark(t.Mark)
+	w.printf("from %s\n", t.From)
+	w.originalOID(t.OriginalOID)
+	if t.Tagger != nil {
+		w.printf("tagger %s\n", t.Tagger)
+	}
+	w.data([]byte(t.Message))
+	return w.err
+}
+
+func (w *Writer) Reset(r *Reset) error {
+	w.printf("reset %s\n", r.Ref)
+	if r.From != "" {
+		w.printf("from %s\n", r.From)
+	}
+	w.printf("\n")
+	return w.err
+}
+
+func (w *Writer) Alias(a *Alias) error {
+	w.printf("alias\n")
+	w.mark(a.Mark)
+	w.printf("to %s\n\n", a.To)
+	return w.err
+}
+
+// Feature writes a feature command. arg is omitted when empty.
+func (w *Writer) Feature(name, arg string) error {
+	if arg == "" {
+		w.printf("feature %s\n", name)
+	} else {
+		w.printf("feature %s=%s\n", name, arg)
+	}
+	return w.err
+}
+
+func (w *Writer) Option(option string) error {
+	w.printf("option %s\n", option)
+	return w.err
+}
+
+func (w *Writer) Progress(msg string) error {
+	w.printf("progress %s\n", msg)
+	return w.err
+}
+
+func (w *Writer) Checkpoint() error {
+	w.printf("checkpoint\n")
+	return w.err
+}
+
+func (w *Writer) Done() error {
+	w.printf("done\n")
+	return w.Flush()
+}
+
+// Ls asks fast-import for the entry at path in the tree of dataref. When
+// dataref is empty the tree of the current commit is used; the path is
+// then always quoted, as fast-import would read an unquoted one as a
+// dataref. The response is sent back on the bidi-import channel.
+func (w *Writer) Ls(dataref, path string) error {
+	if dataref == "" {
+		w.printf("ls %s\n", quotePath(path))
+	} else {
+		w.printf("ls %s %s\n", dataref, QuotePath(path, false))
+	}
+	return w.Flush()
+}
+
+// CatBlob asks fast-import for the contents of a blob. The response is sent
+// back on the bidi-import channel.
+func (w *Writer) CatBlob(dataref string) error {
+	w.printf("cat-blob %s\n", dataref)
+	return w.Flush()
+}
+
+// GetMark asks fast-import for the object id of mark. The response is sent
+// back on the bidi-import channel.
+func (w *Writer) GetMark(mark Mark) error {
+	w.printf("get-mark %s\n", mark)
+	return w.Flush()
+}
+
+// Flush flushes the underlying writer when it is buffered.
+func (w *Writer) Flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	if f, ok := w.w.(flusher); ok {
+		w.err = f.Flush()
+	}
+	return w.err
+}
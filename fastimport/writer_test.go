@@ -0,0 +1,241 @@
+package fastimport
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func mustIdent(t *testing.T, s string) *Ident {
+	ident, err := ParseIdent(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &ident
+}
+
+func TestRoundTrip(t *testing.T) {
+	author := mustIdent(t, "A U Thor <author@example.com> 1234567890 +0200")
+	committer := mustIdent(t, "C O Mitter <committer@example.com> 1234567890 -0130")
+
+	tests := []struct {
+		name string
+		cmd  Command
+	}{
+		{
+			name: "blob",
+			cmd:  &Blob{Mark: 1, OriginalOID: "0123456789abcdef0123456789abcdef01234567", Data: []byte("hello\nworld")},
+		},
+		{
+			name: "empty blob",
+			cmd:  &Blob{Mark: 2, Data: []byte{}},
+		},
+		{
+			name: "commit",
+			cmd: &Commit{
+				Ref:       "refs/heads/master",
+				Mark:      3,
+				Author:    author,
+				Committer: *committer,
+				Encoding:  "iso-8859-1",
+				Message:   "subject\n\nbody\n",
+				From:      ":2",
+				Merge:     []string{":4", "0123456789abcdef0123456789abcdef01234567"},
+				Ops: []FileOp{
+					FileModify{Mode: ModeFile, DataRef: ":1", Path: "plain.txt"},
+					FileModify{Mode: ModeExecutable, Data: []byte("#!/bin/sh\n"), Path: "dir/with space"},
+					FileModify{Mode: ModeSymlink, DataRef: ":1", Path: "\"quoted\"\n"},
+					FileDelete{Path: "gone"},
+					FileCopy{Src: "a b", Dst: "c"},
+					FileRename{Src: "d", Dst: "e f"},
+					NoteModify{DataRef: ":1", Commit: ":3"},
+					NoteModify{Data: []byte("note"), Commit: ":3"},
+				},
+			},
+		},
+		{
+			name: "commit without author",
+			cmd:  &Commit{Ref: "refs/heads/x", Committer: *committer, Message: "m", Ops: []FileOp{FileDeleteAll{}}},
+		},
+		{
+			name: "tag",
+			cmd:  &Tag{Name: "v1.0", Mark: 5, From: ":3", Tagger: author, Message: "release\n"},
+		},
+		{
+			name: "reset",
+			cmd:  &Reset{Ref: "refs/heads/y", From: ":3"},
+		},
+		{
+			name: "reset without from",
+			cmd:  &Reset{Ref: "refs/heads/z"},
+		},
+		{
+			name: "alias",
+			cmd:  &Alias{Mark: 6, To: ":3"},
+		},
+		{
+			name: "feature",
+			cmd:  &Feature{Name: "export-marks", Arg: "/tmp/marks"},
+		},
+		{
+			name: "option",
+			cmd:  &Option{Option: "git quiet"},
+		},
+		{
+			name: "progress",
+			cmd:  &Progress{Message: "half way"},
+		},
+		{
+			name: "checkpoint",
+			cmd:  &Checkpoint{},
+		},
+	}
+
+	for _, test := range tests {
+		var (
+			buf bytes.Buffer
+			w   = NewWriter(&buf)
+			err error
+		)
+
+		switch cmd := test.cmd.(type) {
+		case *Blob:
+			err = w.Blob(cmd)
+		case *Commit:
+			err = w.Commit(cmd)
+		case *Tag:
+			err = w.Tag(cmd)
+		case *Reset:
+			err = w.Reset(cmd)
+		case *Alias:
+			err = w.Alias(cmd)
+		case *Feature:
+			err = w.Feature(cmd.Name, cmd.Arg)
+		case *Option:
+			err = w.Option(cmd.Option)
+		case *Progress:
+			err = w.Progress(cmd.Message)
+		case *Checkpoint:
+			err = w.Checkpoint()
+		}
+		if err != nil {
+			t.Errorf("%s: write: %s", test.name, err)
+			continue
+		}
+		stream := buf.String()
+
+		r := NewReader(&buf)
+		got, err := r.Next()
+		if err != nil {
+			t.Errorf("%s: read: %s\n%s", test.name, err, stream)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.cmd) {
+			t.Errorf("%s: round trip mismatch\nstream: %q\n got: %#v\nwant: %#v", test.name, stream, got, test.cmd)
+		}
+
+		_, err = r.Next()
+		if err != io.EOF {
+			t.Errorf("%s: expected io.EOF after the command, got %v", test.name, err)
+		}
+	}
+}
+
+func TestDone(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Feature("done", "")
+	w.Progress("p")
+	w.Done()
+	buf.WriteString("trailing data\n")
+
+	r := NewReader(&buf)
+	for _, want := range []Command{&Feature{Name: "done"}, &Progress{Message: "p"}, &Done{}} {
+		got, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %#v, want %#v", got, want)
+		}
+	}
+
+	_, err := r.Next()
+	if err != io.EOF {
+		t.Errorf("expected io.EOF after done, got %v", err)
+	}
+}
+
+func TestDoneMissing(t *testing.T) {
+	r := NewReader(bytes.NewBufferString("feature done\nprogress p\n"))
+	r.Next()
+	r.Next()
+
+	_, err := r.Next()
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+func TestDelimitedData(t *testing.T) {
+	r := NewReader(bytes.NewBufferString("blob\nmark :1\ndata <<EOT\nline 1\nline 2\nEOT\n"))
+
+	got, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Blob{Mark: 1, Data: []byte("line 1\nline 2\n")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Blob(&Blob{Mark: 1, Data: []byte("x")})
+	w.Alias(&Alias{Mark: 2, To: ":1"})
+
+	r := NewReader(&buf)
+	r.Next()
+	r.Next()
+
+	cmd, ok := r.Resolve(":2")
+	if blob, isBlob := cmd.(*Blob); !ok || !isBlob || blob.Mark != 1 {
+		t.Errorf("Resolve(:2) = %#v, %v", cmd, ok)
+	}
+	if _, ok := r.Resolve(":3"); ok {
+		t.Error("Resolve(:3) should fail")
+	}
+	if _, ok := r.Resolve("0123456789abcdef0123456789abcdef01234567"); ok {
+		t.Error("Resolve(<oid>) should fail")
+	}
+}
+
+func TestRequests(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(w *Writer) error
+		want  string
+	}{
+		{"ls dataref", func(w *Writer) error { return w.Ls(":1", "dir/file") }, "ls :1 dir/file\n"},
+		{"ls dataref quoted", func(w *Writer) error { return w.Ls(":1", "a\nb") }, "ls :1 \"a\\nb\"\n"},
+		{"ls active commit", func(w *Writer) error { return w.Ls("", "dir/file") }, "ls \"dir/file\"\n"},
+		{"ls active commit with space", func(w *Writer) error { return w.Ls("", "a b") }, "ls \"a b\"\n"},
+		{"cat-blob", func(w *Writer) error { return w.CatBlob(":7") }, "cat-blob :7\n"},
+		{"get-mark", func(w *Writer) error { return w.GetMark(7) }, "get-mark :7\n"},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+		err := test.write(NewWriter(&buf))
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if buf.String() != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, buf.String(), test.want)
+		}
+	}
+}
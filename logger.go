@@ -0,0 +1,160 @@
+package gitremote
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogEnv names the environment variable which enables JSON logging. It
+// holds the path of a file the records are appended to.
+const LogEnv = "GIT_REMOTE_HELPER_LOG"
+
+// LogLevel is the severity of a log message. The levels line up with the
+// verbosity Git passes through `option verbosity`: a message is printed on
+// stderr when its level is not above the verbosity.
+type LogLevel int
+
+const (
+	LogError LogLevel = iota // always printed, even with --quiet
+	LogInfo                  // printed by default
+	LogDebug                 // printed with -v
+	LogTrace                 // printed with -vv
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LogError:
+		return "error"
+	case LogInfo:
+		return "info"
+	case LogDebug:
+		return "debug"
+	case LogTrace:
+		return "trace"
+	default:
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+}
+
+// LogRecord is a single JSON log line.
+type LogRecord struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Remote  string    `json:"remote,omitempty"`
+	Command string    `json:"command,omitempty"`
+	Message string    `json:"message"`
+}
+
+// Logger is a leveled logger writing human readable lines to stderr and,
+// optionally, every record as JSON to a second writer. The runner hands
+// each command a Logger tagged with the command and the remote name. A nil
+// *Logger discards everything.
+//
+// Logger is safe for concurrent use.
+type Logger struct {
+	sink      *logSink
+	remote    string
+	command   string
+	verbosity int
+}
+
+type logSink struct {
+	mtx    sync.Mutex
+	stderr io.Writer
+	json   *json.Encoder
+}
+
+// NewLogger returns a Logger printing to stderr at the default verbosity.
+// All records, whatever their level, are written to jsonw when it is not
+// nil.
+func NewLogger(stderr, jsonw io.Writer) *Logger {
+	sink := &logSink{stderr: stderr}
+	if jsonw != nil {
+		sink.json = json.NewEncoder(jsonw)
+	}
+	return &Logger{sink: sink, verbosity: 1}
+}
+
+// With returns a Logger tagged with remote and command which prints
+// messages up to verbosity. Records still go to the same writers.
+func (l *Logger) With(remote, command string, verbosity int) *Logger {
+	if l == nil {
+		return nil
+	}
+	return &Logger{sink: l.sink, remote: remote, command: command, verbosity: verbosity}
+}
+
+// Enabled reports whether messages at level are printed on stderr.
+func (l *Logger) Enabled(level LogLevel) bool {
+	return l != nil && l.sink.stderr != nil && int(level) <= l.verbosity
+}
+
+func (l *Logger) Errorf(format string, args ...interface{}) { l.Logf(LogError, format, args...) }
+func (l *Logger) Infof(format string, args ...interface{})  { l.Logf(LogInfo, format, args...) }
+func (l *Logger) Debugf(format string, args ...interface{}) { l.Logf(LogDebug, format, args...) }
+func (l *Logger) Tracef(format string, args ...interface{}) { l.Logf(LogTrace, format, args...) }
+
+// Logf logs a message at level.
+func (l *Logger) Logf(level LogLevel, format string, args ...interface{}) {
+	if l == nil || (!l.Enabled(level) && l.sink.json == nil) {
+		return
+	}
+
+	msg := strings.TrimSuffix(fmt.Sprintf(format, args...), "\n")
+
+	l.sink.mtx.Lock()
+	defer l.sink.mtx.Unlock()
+
+	if l.sink.json != nil {
+		l.sink.json.Encode(LogRecord{
+			Time:    time.Now().UTC(),
+			Level:   level.String(),
+			Remote:  l.remote,
+			Command: l.command,
+			Message: msg,
+		})
+	}
+
+	if l.Enabled(level) {
+		fmt.Fprintf(l.sink.stderr, "%s%s\n", l.prefix(level), msg)
+	}
+}
+
+func (l *Logger) prefix(level LogLevel) string {
+	var tags []string
+	if l.remote != "" {
+		tags = append(tags, l.remote)
+	}
+	if l.command != "" {
+		tags = append(tags, l.command)
+	}
+
+	prefix := ""
+	if len(tags) > 0 {
+		prefix = "[" + strings.Join(tags, " ") + "] "
+	}
+	if level == LogError {
+		prefix += "error: "
+	}
+	return prefix
+}
+
+// logFileFromEnv opens the file named by LogEnv. It returns nil when JSON
+// logging is disabled.
+func logFileFromEnv() (io.WriteCloser, error) {
+	v := os.Getenv(LogEnv)
+	if v == "" {
+		return nil, nil
+	}
+
+	f, err := os.OpenFile(v, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", LogEnv, err)
+	}
+	return f, nil
+}
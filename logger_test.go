@@ -0,0 +1,82 @@
+package gitremote
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+type closeRecorder struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestRunClosesLogFile(t *testing.T) {
+	var (
+		stderr bytes.Buffer
+		file   = &closeRecorder{}
+		h      = &transcriptHelper{name: "refs/heads/master"}
+	)
+
+	config := Config{
+		Helper: h,
+		Stdin:  strings.NewReader("list\n\n"),
+		Stdout: &bytes.Buffer{},
+		Log:    NewLogger(&stderr, file),
+		files:  []io.Closer{file},
+	}
+
+	err := Run(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !file.closed {
+		t.Error("the log file was not closed when Run returned")
+	}
+
+	// a config which failed is closed too
+	file = &closeRecorder{}
+	config.Err = os.ErrNotExist
+	config.files = []io.Closer{file}
+	if err := Run(context.Background(), config); err != os.ErrNotExist {
+		t.Fatalf("got %v, want the config error", err)
+	}
+	if !file.closed {
+		t.Error("the log file of a failed config was not closed")
+	}
+}
+
+func TestLoggerJSON(t *testing.T) {
+	var stderr, jsonw bytes.Buffer
+
+	log := NewLogger(&stderr, &jsonw).With("origin", "fetch", 1)
+	log.Infof("hello %s", "world")
+	log.Debugf("hidden")
+
+	if got, want := stderr.String(), "[origin fetch] hello world\n"; got != want {
+		t.Errorf("stderr: got %q, want %q", got, want)
+	}
+
+	var records int
+	dec := json.NewDecoder(&jsonw)
+	for dec.More() {
+		var rec map[string]interface{}
+		if err := dec.Decode(&rec); err != nil {
+			t.Fatal(err)
+		}
+		records++
+	}
+	if records != 2 {
+		t.Errorf("got %d JSON records, want 2 (all levels)", records)
+	}
+}
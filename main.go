@@ -0,0 +1,83 @@
+package gitremote
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// Exit codes used by Main, following Git's conventions.
+const (
+	ExitUsage = 129 // invalid arguments, like Git's usage()
+	ExitFatal = 128 // any other error, like Git's die()
+)
+
+// Main runs helper as a git-remote-<name> binary. See MainFunc.
+func Main(helper Helper) {
+	MainFunc(func(Config) (Helper, error) { return helper, nil })
+}
+
+// MainFunc runs a git-remote-<name> binary. It validates the arguments,
+// makes GIT_DIR absolute (Config.Dir stays empty outside of a repository),
+// builds the helper with fn and serves the session on stdin and stdout. Errors are reported as
+// "fatal: <message>" and the process exits with the status Git expects.
+// MainFunc does not return.
+func MainFunc(fn func(Config) (Helper, error)) {
+	os.Exit(runMain(context.Background(), DefaultConfig(), fn))
+}
+
+func runMain(ctx context.Context, config Config, fn func(Config) (Helper, error)) int {
+	stderr := config.Stderr
+	if stderr == nil {
+		stderr = ioutil.Discard
+	}
+
+	if config.Err == ErrInvalidArguments {
+		fmt.Fprintf(stderr, "usage: git %s <remote> [<url>]\n", helperName())
+		return ExitUsage
+	}
+	if config.Err != nil {
+		return fatal(stderr, config.Err)
+	}
+
+	// Git sets GIT_DIR when it runs a helper in a repository and leaves it
+	// unset otherwise (for example during `git ls-remote <url>`)
+	if config.Dir != "" {
+		if dir, err := filepath.Abs(config.Dir); err == nil {
+			config.Dir = dir
+		}
+	}
+
+	helper, err := fn(config)
+	if err != nil {
+		return fatal(stderr, err)
+	}
+	config.Helper = helper
+
+	return fatal(stderr, Run(ctx, config))
+}
+
+// fatal reports err like Git's die() and returns the exit code for it.
+func fatal(w io.Writer, err error) int {
+	switch e := err.(type) {
+	case nil:
+		return 0
+	case ErrSignal:
+		return e.ExitCode()
+	}
+
+	fmt.Fprintf(w, "fatal: %s\n", strings.TrimSuffix(err.Error(), "."))
+	return ExitFatal
+}
+
+// helperName returns the name Git runs the binary as, like "remote-foo".
+func helperName() string {
+	name := filepath.Base(os.Args[0])
+	name = strings.TrimSuffix(name, ".exe")
+	return strings.TrimPrefix(name, "git-")
+}
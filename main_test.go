@@ -0,0 +1,109 @@
+package gitremote
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestRunMain(t *testing.T) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok := func(Config) (Helper, error) { return &transcriptHelper{name: "refs/heads/master"}, nil }
+
+	tests := []struct {
+		name   string
+		config Config
+		fn     func(Config) (Helper, error)
+		code   int
+		stderr string
+		dir    string
+	}{
+		{
+			name:   "session",
+			config: Config{Stdin: strings.NewReader("list\n\n")},
+			fn:     ok,
+		},
+		{
+			name:   "usage",
+			config: Config{Err: ErrInvalidArguments},
+			fn:     ok,
+			code:   ExitUsage,
+			stderr: "usage: git ",
+		},
+		{
+			name:   "config error",
+			config: Config{Err: errors.New("bad config.")},
+			fn:     ok,
+			code:   ExitFatal,
+			stderr: "fatal: bad config\n",
+		},
+		{
+			name:   "helper error",
+			config: Config{},
+			fn:     func(Config) (Helper, error) { return nil, errors.New("no backend") },
+			code:   ExitFatal,
+			stderr: "fatal: no backend\n",
+		},
+		{
+			name:   "session error",
+			config: Config{Stdin: strings.NewReader("bogus\n\n")},
+			fn:     ok,
+			code:   ExitFatal,
+			stderr: "fatal: invalid command: \"bogus\"\n",
+		},
+		{
+			name:   "signal",
+			config: Config{},
+			fn:     func(Config) (Helper, error) { return nil, ErrSignal{Signal: syscall.SIGTERM} },
+			code:   128 + int(syscall.SIGTERM),
+		},
+		{
+			name:   "no GIT_DIR",
+			config: Config{Stdin: strings.NewReader("\n")},
+			fn:     ok,
+			dir:    "",
+		},
+		{
+			name:   "relative GIT_DIR",
+			config: Config{Dir: ".git", Stdin: strings.NewReader("\n")},
+			fn:     ok,
+			dir:    filepath.Join(cwd, ".git"),
+		},
+	}
+
+	for _, test := range tests {
+		var (
+			stderr bytes.Buffer
+			dir    string
+			config = test.config
+		)
+
+		config.Stderr = &stderr
+		config.Stdout = &bytes.Buffer{}
+
+		code := runMain(context.Background(), config, func(c Config) (Helper, error) {
+			dir = c.Dir
+			return test.fn(c)
+		})
+
+		if code != test.code {
+			t.Errorf("%s: exit code %d, want %d", test.name, code, test.code)
+		}
+		if !strings.HasPrefix(stderr.String(), test.stderr) || (test.stderr == "" && stderr.Len() > 0) {
+			t.Errorf("%s: stderr %q, want %q", test.name, stderr.String(), test.stderr)
+		}
+		if dir != test.dir {
+			t.Errorf("%s: Dir = %q, want %q", test.name, dir, test.dir)
+		}
+	}
+}
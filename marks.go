@@ -0,0 +1,244 @@
+package gitremote
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/fd/go-git-remote-helper/fastimport"
+)
+
+// Marks maps fast-import marks to Git object ids and to the ids used by
+// the backend of the helper. The Git marks are kept in the files Git uses
+// for the import-marks/export-marks capabilities; the backend ids are kept
+// next to them in files with a ".native" suffix.
+//
+// The Git marks files belong to git fast-export and git fast-import and
+// are never written here. The runner loads the marks from the import-marks
+// file at startup, re-reads the export-marks file after every export and
+// saves the backend ids next to the export-marks file after every import
+// and export command. Marks is safe for concurrent use.
+type Marks struct {
+	mtx        sync.Mutex
+	importPath string
+	path       string
+	oids       map[fastimport.Mark]string
+	natives    map[fastimport.Mark]string
+	byOID      map[string]fastimport.Mark
+	byNative   map[string]fastimport.Mark
+	last       fastimport.Mark
+}
+
+// LoadMarks loads the marks stored at path. Missing files are treated as
+// empty.
+func LoadMarks(path string) (*Marks, error) {
+	return loadMarks(path, path)
+}
+
+// loadMarks loads the marks stored at importPath. Reload re-reads
+// exportPath and Save writes the backend ids next to it.
+func loadMarks(importPath, exportPath string) (*Marks, error) {
+	m := &Marks{
+		importPath: importPath,
+		path:       exportPath,
+		oids:       make(map[fastimport.Mark]string),
+		natives:    make(map[fastimport.Mark]string),
+		byOID:      make(map[string]fastimport.Mark),
+		byNative:   make(map[string]fastimport.Mark),
+	}
+
+	err := m.load(m.importPath, m.setOID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.load(m.importPath+".native", m.setNative)
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (m *Marks) nativePath() string {
+	return m.path + ".native"
+}
+
+func (m *Marks) load(path string, set func(fastimport.Mark, string)) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := s.Text()
+		if line == "" {
+			continue
+		}
+
+		parts := strings.SplitN(line, " ", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("corrupt mark line in %s: %q", path, line)
+		}
+
+		mark, err := fastimport.ParseMark(parts[0])
+		if err != nil {
+			return fmt.Errorf("corrupt mark line in %s: %q", path, line)
+		}
+
+		set(mark, parts[1])
+	}
+
+	return s.Err()
+}
+
+// Path returns the path of the Git marks file, the one Git writes when
+// import-marks and export-marks differ.
+func (m *Marks) Path() string {
+	return m.path
+}
+
+func (m *Marks) setOID(mark fastimport.Mark, oid string) {
+	if prev, ok := m.oids[mark]; ok {
+		delete(m.byOID, prev)
+	}
+	m.oids[mark] = oid
+	m.byOID[oid] = mark
+	if mark > m.last {
+		m.last = mark
+	}
+}
+
+func (m *Marks) setNative(mark fastimport.Mark, native string) {
+	if prev, ok := m.natives[mark]; ok {
+		delete(m.byNative, prev)
+	}
+	m.natives[mark] = native
+	m.byNative[native] = mark
+	if mark > m.last {
+		m.last = mark
+	}
+}
+
+// SetOID records the Git object id of mark in memory. It is not saved;
+// Git writes its own marks file.
+func (m *Marks) SetOID(mark fastimport.Mark, oid string) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.setOID(mark, oid)
+}
+
+// SetNative records the backend id of mark. It is written by Save.
+func (m *Marks) SetNative(mark fastimport.Mark, native string) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.setNative(mark, native)
+}
+
+// OID returns the Git object id of mark.
+func (m *Marks) OID(mark fastimport.Mark) (string, bool) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	oid, ok := m.oids[mark]
+	return oid, ok
+}
+
+// Native returns the backend id of mark.
+func (m *Marks) Native(mark fastimport.Mark) (string, bool) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	native, ok := m.natives[mark]
+	return native, ok
+}
+
+// LookupOID returns the mark of a Git object id.
+func (m *Marks) LookupOID(oid string) (fastimport.Mark, bool) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	mark, ok := m.byOID[oid]
+	return mark, ok
+}
+
+// LookupNative returns the mark of a backend id.
+func (m *Marks) LookupNative(native string) (fastimport.Mark, bool) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	mark, ok := m.byNative[native]
+	return mark, ok
+}
+
+// Last returns the highest known mark.
+func (m *Marks) Last() fastimport.Mark {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return m.last
+}
+
+// Reload re-reads the Git marks file, picking up the marks Git wrote since
+// the marks were loaded.
+func (m *Marks) Reload() error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.oids = make(map[fastimport.Mark]string)
+	m.byOID = make(map[string]fastimport.Mark)
+
+	return m.load(m.path, m.setOID)
+}
+
+// Save atomically writes the backend ids to the ".native" file.
+func (m *Marks) Save() error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	return writeMarks(m.nativePath(), m.natives)
+}
+
+func writeMarks(path string, values map[fastimport.Mark]string) error {
+	marks := make([]int, 0, len(values))
+	for mark := range values {
+		marks = append(marks, int(mark))
+	}
+	sort.Ints(marks)
+
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	for _, mark := range marks {
+		fmt.Fprintf(w, "%s %s\n", fastimport.Mark(mark), values[fastimport.Mark(mark)])
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
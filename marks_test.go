@@ -0,0 +1,171 @@
+package gitremote
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestMarksNeverWriteGitFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "marks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "git.marks")
+	err = ioutil.WriteFile(path, []byte(":1 aaaa\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := LoadMarks(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Git writes a new mark in the meantime
+	err = ioutil.WriteFile(path, []byte(":1 aaaa\n:2 bbbb\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.SetOID(3, "cccc")
+	m.SetNative(2, "native-b")
+	err = m.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != ":1 aaaa\n:2 bbbb\n" {
+		t.Errorf("Git marks file was changed: %q", data)
+	}
+	native, err := ioutil.ReadFile(path + ".native")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(native) != ":2 native-b\n" {
+		t.Errorf("native marks: got %q", native)
+	}
+
+	err = m.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if oid, _ := m.OID(2); oid != "bbbb" {
+		t.Errorf("OID(:2) after Reload = %q, want bbbb", oid)
+	}
+	if mark, ok := m.LookupNative("native-b"); !ok || mark != 2 {
+		t.Errorf("LookupNative after Reload = %v, %v", mark, ok)
+	}
+	if m.Last() != 3 {
+		t.Errorf("Last() = %v, want :3", m.Last())
+	}
+}
+
+// marksHelper declares different import-marks and export-marks files and
+// records the marks the runner loaded.
+type marksHelper struct {
+	BaseHelper
+	importPath string
+	exportPath string
+	marks      *Marks
+}
+
+func (h *marksHelper) Capabilities() Capabilities {
+	return Capabilities{
+		Mandatory:   CapImport,
+		Optional:    CapImportMarks | CapExportMarks,
+		ImportMarks: h.importPath,
+		ExportMarks: h.exportPath,
+	}
+}
+
+func (h *marksHelper) List(ctx context.Context, cmd *CmdList) ([]ListRef, error) {
+	h.marks = cmd.Config.Marks
+	return nil, nil
+}
+
+func TestMarksImportAndExportPaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "marks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	h := &marksHelper{
+		importPath: filepath.Join(dir, "old.marks"),
+		exportPath: filepath.Join(dir, "new.marks"),
+	}
+
+	err = ioutil.WriteFile(h.importPath, []byte(":1 aaaa\n:2 bbbb\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(h.importPath+".native", []byte(":2 native-b\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Run(context.Background(), Config{Helper: h, Stdin: strings.NewReader("list\n\n"), Stdout: ioutil.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := h.marks
+	if m == nil {
+		t.Fatal("the runner did not load the marks")
+	}
+	if m.Last() != 2 {
+		t.Errorf("Last() = %v, want :2 from the import-marks file", m.Last())
+	}
+	if oid, _ := m.OID(1); oid != "aaaa" {
+		t.Errorf("OID(:1) = %q, want aaaa", oid)
+	}
+	if native, _ := m.Native(2); native != "native-b" {
+		t.Errorf("Native(:2) = %q, want native-b", native)
+	}
+	if m.Path() != h.exportPath {
+		t.Errorf("Path() = %q, want %q", m.Path(), h.exportPath)
+	}
+
+	// Git writes every mark to the export-marks file after an export
+	err = ioutil.WriteFile(h.exportPath, []byte(":1 aaaa\n:2 bbbb\n:3 cccc\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Last() != 3 {
+		t.Errorf("Last() after Reload = %v, want :3", m.Last())
+	}
+
+	err = m.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	native, err := ioutil.ReadFile(h.exportPath + ".native")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(native) != ":2 native-b\n" {
+		t.Errorf("native marks next to the export-marks file: got %q", native)
+	}
+	old, err := ioutil.ReadFile(h.importPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(old) != ":1 aaaa\n:2 bbbb\n" {
+		t.Errorf("import-marks file was changed: %q", old)
+	}
+}
@@ -0,0 +1,240 @@
+package gitremote
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Middleware wraps a Helper to add behaviour around its commands.
+type Middleware func(Helper) Helper
+
+// Chain wraps h with mws. The first middleware is the outermost one.
+func Chain(h Helper, mws ...Middleware) Helper {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// InterceptFunc is called around every command of a Helper. method is the
+// name of the protocol command (option, list, fetch, push, export, import,
+// connect, stateless-connect or unknown) and next runs the command.
+//
+// Helper.SetOption takes no context, so option runs without the session
+// context: ctx is context.Background() and is never cancelled.
+type InterceptFunc func(ctx context.Context, method string, next func() error) error
+
+// Intercept returns a middleware which calls fn around every command.
+// Capabilities is not intercepted.
+func Intercept(fn InterceptFunc) Middleware {
+	return func(h Helper) Helper {
+		return &interceptor{Helper: h, fn: fn}
+	}
+}
+
+type interceptor struct {
+	Helper
+	fn InterceptFunc
+}
+
+// SetOption has no session context to pass on (see InterceptFunc).
+func (i *interceptor) SetOption(key, value string) error {
+	return i.fn(context.Background(), "option", func() error {
+		return i.Helper.SetOption(key, value)
+	})
+}
+
+func (i *interceptor) List(ctx context.Context, cmd *CmdList) ([]ListRef, error) {
+	var refs []ListRef
+	err := i.fn(ctx, "list", func() error {
+		var err error
+		refs, err = i.Helper.List(ctx, cmd)
+		return err
+	})
+	return refs, err
+}
+
+func (i *interceptor) Fetch(ctx context.Context, cmd *CmdFetch) (FetchResult, error) {
+	var res FetchResult
+	err := i.fn(ctx, "fetch", func() error {
+		var err error
+		res, err = i.Helper.Fetch(ctx, cmd)
+		return err
+	})
+	return res, err
+}
+
+func (i *interceptor) Push(ctx context.Context, cmd *CmdPush) error {
+	return i.fn(ctx, "push", func() error {
+		return i.Helper.Push(ctx, cmd)
+	})
+}
+
+func (i *interceptor) Export(ctx context.Context, cmd *CmdExport) error {
+	return i.fn(ctx, "export", func() error {
+		return i.Helper.Export(ctx, cmd)
+	})
+}
+
+func (i *interceptor) Import(ctx context.Context, cmd *CmdImport) error {
+	return i.fn(ctx, "import", func() error {
+		return i.Helper.Import(ctx, cmd)
+	})
+}
+
+func (i *interceptor) Connect(ctx context.Context, cmd *CmdConnect) error {
+	return i.fn(ctx, "connect", func() error {
+		return i.Helper.Connect(ctx, cmd)
+	})
+}
+
+func (i *interceptor) StatelessConnect(ctx context.Context, cmd *CmdStatelessConnect) (StatelessConn, error) {
+	var conn StatelessConn
+	err := i.fn(ctx, "stateless-connect", func() error {
+		var err error
+		conn, err = i.Helper.StatelessConnect(ctx, cmd)
+		return err
+	})
+	return conn, err
+}
+
+func (i *interceptor) Unknown(ctx context.Context, cmd *CmdUnknown) error {
+	return i.fn(ctx, "unknown", func() error {
+		return i.Helper.Unknown(ctx, cmd)
+	})
+}
+
+// Observe calls fn after every command with its duration and error. Use
+// it to collect metrics.
+func Observe(fn func(method string, d time.Duration, err error)) Middleware {
+	return Intercept(func(ctx context.Context, method string, next func() error) error {
+		start := time.Now()
+		err := next()
+		fn(method, time.Since(start), err)
+		return err
+	})
+}
+
+// Logging logs every command with its duration and error using logf.
+func Logging(logf func(format string, args ...interface{})) Middleware {
+	return Observe(func(method string, d time.Duration, err error) {
+		if err != nil {
+			logf("%s failed after %s: %s", method, d, err)
+		} else {
+			logf("%s done in %s", method, d)
+		}
+	})
+}
+
+// Authorize calls check before every command. The command is not run when
+// check returns an error. For option, ctx is not the session context (see
+// InterceptFunc).
+func Authorize(check func(ctx context.Context, method string) error) Middleware {
+	return Intercept(func(ctx context.Context, method string, next func() error) error {
+		err := check(ctx, method)
+		if err != nil {
+			return err
+		}
+		return next()
+	})
+}
+
+// Retry retries list and fetch (which are idempotent) up to attempts times
+// in total, waiting backoff before the first retry and doubling it after
+// every failed attempt. Values of attempts below 1 are treated as 1.
+func Retry(attempts int, backoff time.Duration) Middleware {
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	return Intercept(func(ctx context.Context, method string, next func() error) error {
+		if method != "list" && method != "fetch" {
+			return next()
+		}
+
+		var (
+			err  error
+			wait = backoff
+		)
+
+		for i := 0; i < attempts; i++ {
+			if i > 0 {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(wait):
+				}
+				wait *= 2
+			}
+
+			err = next()
+			if err == nil || err == ErrUnsupportedCommand {
+				return err
+			}
+		}
+
+		return err
+	})
+}
+
+// CacheList caches the refs returned by List for the rest of the session.
+// The cache is dropped after every push or export.
+func CacheList() Middleware {
+	return func(h Helper) Helper {
+		return &listCache{Helper: h}
+	}
+}
+
+type listCache struct {
+	Helper
+	mtx   sync.Mutex
+	cache map[bool]cachedList
+}
+
+type cachedList struct {
+	refs     []ListRef
+	keywords []ListKeyword
+}
+
+func (c *listCache) List(ctx context.Context, cmd *CmdList) ([]ListRef, error) {
+	c.mtx.Lock()
+	cached, ok := c.cache[cmd.ForPush]
+	c.mtx.Unlock()
+
+	if ok {
+		cmd.Keywords = cached.keywords
+		return cached.refs, nil
+	}
+
+	refs, err := c.Helper.List(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mtx.Lock()
+	if c.cache == nil {
+		c.cache = make(map[bool]cachedList)
+	}
+	c.cache[cmd.ForPush] = cachedList{refs: refs, keywords: cmd.Keywords}
+	c.mtx.Unlock()
+
+	return refs, nil
+}
+
+func (c *listCache) Push(ctx context.Context, cmd *CmdPush) error {
+	c.drop()
+	return c.Helper.Push(ctx, cmd)
+}
+
+func (c *listCache) Export(ctx context.Context, cmd *CmdExport) error {
+	c.drop()
+	return c.Helper.Export(ctx, cmd)
+}
+
+func (c *listCache) drop() {
+	c.mtx.Lock()
+	c.cache = nil
+	c.mtx.Unlock()
+}
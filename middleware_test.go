@@ -0,0 +1,53 @@
+package gitremote
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+type flakyHelper struct {
+	BaseHelper
+	failures int
+	calls    int
+}
+
+func (h *flakyHelper) Capabilities() Capabilities {
+	return Capabilities{Mandatory: CapFetch}
+}
+
+func (h *flakyHelper) List(ctx context.Context, cmd *CmdList) ([]ListRef, error) {
+	h.calls++
+	if h.calls <= h.failures {
+		return nil, errors.New("temporary failure")
+	}
+	return []ListRef{{Name: "refs/heads/master", Hash: SHA1.ZeroHash()}}, nil
+}
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		attempts int
+		failures int
+		calls    int
+		ok       bool
+	}{
+		{attempts: 0, failures: 0, calls: 1, ok: true},
+		{attempts: -1, failures: 1, calls: 1, ok: false},
+		{attempts: 1, failures: 1, calls: 1, ok: false},
+		{attempts: 3, failures: 2, calls: 3, ok: true},
+		{attempts: 3, failures: 5, calls: 3, ok: false},
+	}
+
+	for _, test := range tests {
+		h := &flakyHelper{failures: test.failures}
+		refs, err := Retry(test.attempts, 0)(h).List(context.Background(), &CmdList{})
+
+		if h.calls != test.calls {
+			t.Errorf("Retry(%d): %d calls, want %d", test.attempts, h.calls, test.calls)
+		}
+		if ok := err == nil && len(refs) == 1; ok != test.ok {
+			t.Errorf("Retry(%d): got %v, %v", test.attempts, refs, err)
+		}
+	}
+}
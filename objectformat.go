@@ -0,0 +1,86 @@
+package gitremote
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ObjectFormat is the hash algorithm of a repository.
+type ObjectFormat string
+
+const (
+	SHA1   ObjectFormat = "sha1"
+	SHA256 ObjectFormat = "sha256"
+)
+
+type ErrInvalidHash struct {
+	Format ObjectFormat
+	Hash   string
+}
+
+func (e ErrInvalidHash) Error() string {
+	return fmt.Sprintf("invalid %s object id: %q", e.Format, e.Hash)
+}
+
+type ErrUnsupportedObjectFormat ObjectFormat
+
+func (e ErrUnsupportedObjectFormat) Error() string {
+	return fmt.Sprintf("unsupported object format: %q", string(e))
+}
+
+// HexSize returns the length of a hex encoded object id, or 0 for unknown
+// formats.
+func (f ObjectFormat) HexSize() int {
+	switch f {
+	case SHA1:
+		return 40
+	case SHA256:
+		return 64
+	default:
+		return 0
+	}
+}
+
+// ZeroHash returns the all-zero object id of f.
+func (f ObjectFormat) ZeroHash() string {
+	return strings.Repeat("0", f.HexSize())
+}
+
+// ValidHash returns true when hash is a full hex encoded object id in
+// format f.
+func (f ObjectFormat) ValidHash(hash string) bool {
+	return len(hash) == f.HexSize() && isHex(hash)
+}
+
+func (f ObjectFormat) checkHash(hash string) error {
+	if !f.ValidHash(hash) {
+		return ErrInvalidHash{Format: f, Hash: hash}
+	}
+	return nil
+}
+
+// looksLikeHash returns true when s is an object id in any known format.
+func looksLikeHash(s string) bool {
+	return SHA1.ValidHash(s) || SHA256.ValidHash(s)
+}
+
+func isHex(s string) bool {
+	return s != "" && strings.Trim(s, "0123456789abcdef") == ""
+}
+
+// negotiateObjectFormat handles the value of the object-format option.
+// "true" asks for the format of the remote to be reported by list; an
+// algorithm name asks to use that algorithm, which must be the format of
+// the remote.
+func (r *runner) negotiateObjectFormat(value string) error {
+	if value == "true" {
+		return nil
+	}
+
+	f := ObjectFormat(value)
+	if f.HexSize() == 0 || f != r.ObjectFormat {
+		return ErrUnsupportedObjectFormat(value)
+	}
+
+	return nil
+}
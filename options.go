@@ -0,0 +1,194 @@
+package gitremote
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Options holds the values of the standard options Git sets with the
+// option command. The current values are exposed on the Config of every
+// command. Git sets push-option and cas before every push or export and
+// deepen-not before every fetch, so those are cleared once the batch which
+// used them is done.
+type Options struct {
+	Verbosity      int      // verbosity <n>
+	Progress       bool     // progress {true|false}
+	Depth          int      // depth <depth>, 0 for full history
+	DeepenSince    string   // deepen-since <timestamp>
+	DeepenNot      []string // deepen-not <ref>
+	DeepenRelative bool     // deepen-relative {true|false}
+	FollowTags     bool     // followtags {true|false}
+	DryRun         bool     // dry-run {true|false}
+	CAS            []string // cas <refname>:<expected-value>
+	Force          bool     // force {true|false}
+	Cloning        bool     // cloning {true|false}
+	UpdateShallow  bool     // update-shallow {true|false}
+	PushCert       string   // pushcert {true|false|if-asked}
+	PushOptions    []string // push-option <string>
+	Family         string   // family {ipv4|ipv6|all}
+	FromPromisor   bool     // from-promisor {true|false}
+	NoDependents   bool     // no-dependents {true|false}
+	Filter         string   // filter <filter-spec>
+	Atomic         bool     // atomic {true|false}
+	ObjectFormat   string   // object-format {true|<algorithm>}
+
+	CheckConnectivity bool // check-connectivity {true|false}
+}
+
+type ErrInvalidOption struct {
+	Key   string
+	Value string
+}
+
+func (e ErrInvalidOption) Error() string {
+	return fmt.Sprintf("invalid value for option %s: %q", e.Key, e.Value)
+}
+
+// DefaultOptions returns the options as they are before Git sets any of them.
+func DefaultOptions() Options {
+	return Options{
+		Verbosity: 1,
+		PushCert:  "false",
+		Family:    "all",
+	}
+}
+
+// Set parses value and stores it in the option named key. It returns
+// ErrUnsupportedOption when key is not a standard option and
+// ErrInvalidOption when value cannot be parsed.
+func (o *Options) Set(key, value string) error {
+	var err error
+
+	invalid := func() error {
+		return ErrInvalidOption{Key: key, Value: value}
+	}
+
+	parseBool := func(dst *bool) {
+		switch value {
+		case "true":
+			*dst = true
+		case "false":
+			*dst = false
+		default:
+			err = invalid()
+		}
+	}
+
+	parseUint := func(dst *int) {
+		n, perr := strconv.Atoi(value)
+		if perr != nil || n < 0 {
+			err = invalid()
+			return
+		}
+		*dst = n
+	}
+
+	parseString := func(dst *string) {
+		s, perr := unquoteOption(value)
+		if perr != nil {
+			err = invalid()
+			return
+		}
+		*dst = s
+	}
+
+	appendString := func(dst *[]string) {
+		s, perr := unquoteOption(value)
+		if perr != nil {
+			err = invalid()
+			return
+		}
+		*dst = append(*dst, s)
+	}
+
+	oneOf := func(dst *string, values ...string) {
+		for _, v := range values {
+			if v == value {
+				*dst = value
+				return
+			}
+		}
+		err = invalid()
+	}
+
+	switch key {
+	case "verbosity":
+		parseUint(&o.Verbosity)
+	case "progress":
+		parseBool(&o.Progress)
+	case "depth":
+		parseUint(&o.Depth)
+	case "deepen-since":
+		parseString(&o.DeepenSince)
+	case "deepen-not":
+		appendString(&o.DeepenNot)
+	case "deepen-relative":
+		parseBool(&o.DeepenRelative)
+	case "followtags":
+		parseBool(&o.FollowTags)
+	case "dry-run":
+		parseBool(&o.DryRun)
+	case "cas":
+		appendString(&o.CAS)
+	case "force":
+		parseBool(&o.Force)
+	case "cloning":
+		parseBool(&o.Cloning)
+	case "update-shallow":
+		parseBool(&o.UpdateShallow)
+	case "pushcert":
+		oneOf(&o.PushCert, "true", "false", "if-asked")
+	case "push-option":
+		appendString(&o.PushOptions)
+	case "family":
+		oneOf(&o.Family, "ipv4", "ipv6", "all")
+	case "from-promisor":
+		parseBool(&o.FromPromisor)
+	case "no-dependents":
+		parseBool(&o.NoDependents)
+	case "filter":
+		parseString(&o.Filter)
+	case "atomic":
+		parseBool(&o.Atomic)
+	case "object-format":
+		parseString(&o.ObjectFormat)
+	case "check-connectivity":
+		parseBool(&o.CheckConnectivity)
+	default:
+		return ErrUnsupportedOption
+	}
+
+	return err
+}
+
+// runnerOption reports whether the runner acts on the option itself. Such
+// options are accepted even when the helper does not support them.
+func runnerOption(key string) bool {
+	switch key {
+	case "verbosity", "progress", "object-format", "check-connectivity",
+		"push-option", "cas", "atomic":
+		return true
+	default:
+		return false
+	}
+}
+
+// resetBatch clears the options which only apply to the batch of cmd.
+func (o *Options) resetBatch(cmd Command) {
+	switch cmd.(type) {
+	case *CmdPush, *CmdExport:
+		o.PushOptions = nil
+		o.CAS = nil
+	case *CmdFetch:
+		o.DeepenNot = nil
+	}
+}
+
+// unquoteOption decodes a value which Git may have C-quoted.
+func unquoteOption(s string) (string, error) {
+	if !strings.HasPrefix(s, `"`) {
+		return s, nil
+	}
+	return strconv.Unquote(s)
+}
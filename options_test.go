@@ -0,0 +1,144 @@
+package gitremote
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// optionsHelper declines every option and records the options the runner
+// exposes to list.
+type optionsHelper struct {
+	BaseHelper
+	options Options
+}
+
+func (h *optionsHelper) Capabilities() Capabilities {
+	return Capabilities{Mandatory: CapFetch, Optional: CapOption}
+}
+
+func (h *optionsHelper) List(ctx context.Context, cmd *CmdList) ([]ListRef, error) {
+	h.options = cmd.Config.Options
+	return nil, nil
+}
+
+func TestRunnerOptions(t *testing.T) {
+	in := strings.Join([]string{
+		"option progress true",
+		"option verbosity 2",
+		"option check-connectivity true",
+		"option push-option \"a b\"",
+		"option cas refs/heads/x:",
+		"option atomic true",
+		"option depth 3",
+		"option verbosity x",
+		"list",
+		"",
+		"",
+	}, "\n")
+
+	var (
+		out bytes.Buffer
+		h   = &optionsHelper{}
+	)
+
+	err := Run(context.Background(), Config{Helper: h, Stdin: strings.NewReader(in), Stdout: &out})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "ok\nok\nok\nok\nok\nok\nunsupported\nerror invalid value for option verbosity: \"x\"\n\n"
+	if out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+
+	o := h.options
+	if !o.Progress || o.Verbosity != 2 || !o.CheckConnectivity || !o.Atomic {
+		t.Errorf("runner options were not recorded: %+v", o)
+	}
+	if len(o.PushOptions) != 1 || o.PushOptions[0] != "a b" {
+		t.Errorf("push options: got %q", o.PushOptions)
+	}
+	if len(o.CAS) != 1 || o.CAS[0] != "refs/heads/x:" {
+		t.Errorf("cas: got %q", o.CAS)
+	}
+	if o.Depth != 0 {
+		t.Errorf("depth was recorded although the helper declined it: %d", o.Depth)
+	}
+}
+
+// batchHelper accepts every option and records the options of every push
+// and fetch.
+type batchHelper struct {
+	BaseHelper
+	mtx   sync.Mutex
+	push  []PushOptions
+	fetch map[string][]string // deepen-not by the first ref fetched
+}
+
+func (h *batchHelper) SetOption(key, value string) error {
+	return nil
+}
+
+func (h *batchHelper) Capabilities() Capabilities {
+	return Capabilities{Mandatory: CapFetch, Optional: CapPush | CapOption}
+}
+
+func (h *batchHelper) Fetch(ctx context.Context, cmd *CmdFetch) (FetchResult, error) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.fetch[cmd.Refs[0].Name] = cmd.Config.Options.DeepenNot
+	return FetchResult{}, nil
+}
+
+func (h *batchHelper) Push(ctx context.Context, cmd *CmdPush) error {
+	h.push = append(h.push, cmd.Options)
+	for _, ref := range cmd.Refs {
+		ref.SetStatus(PushOk, nil)
+	}
+	return nil
+}
+
+func TestBatchOptionsReset(t *testing.T) {
+	hash := SHA1.ZeroHash()
+
+	for _, pipeline := range []bool{false, true} {
+		in := strings.Join([]string{
+			"option push-option a",
+			"option cas refs/heads/a:",
+			"push refs/heads/a:refs/heads/a",
+			"",
+			"push refs/heads/b:refs/heads/b",
+			"",
+			"option deepen-not refs/heads/x",
+			"fetch " + hash + " refs/heads/a",
+			"",
+			"fetch " + hash + " refs/heads/b",
+			"",
+			"",
+			"",
+		}, "\n")
+
+		h := &batchHelper{fetch: make(map[string][]string)}
+		err := Run(context.Background(), Config{Helper: h, Stdin: strings.NewReader(in), Stdout: &bytes.Buffer{}, Pipeline: pipeline})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(h.push) != 2 || len(h.fetch) != 2 {
+			t.Fatalf("pipeline=%v: got %d pushes and %d fetches", pipeline, len(h.push), len(h.fetch))
+		}
+		if len(h.push[0].ServerOptions) != 1 || len(h.push[0].CAS) != 1 {
+			t.Errorf("pipeline=%v: first push: got %+v", pipeline, h.push[0])
+		}
+		if len(h.push[1].ServerOptions) != 0 || len(h.push[1].CAS) != 0 {
+			t.Errorf("pipeline=%v: second push inherited the options of the first: %+v", pipeline, h.push[1])
+		}
+		if len(h.fetch["refs/heads/a"]) != 1 || len(h.fetch["refs/heads/b"]) != 0 {
+			t.Errorf("pipeline=%v: deepen-not of the fetches: got %q", pipeline, h.fetch)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package gitremote
+
+import (
+	"bufio"
+	"bytes"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// pipelineDepth is the number of commands which may run ahead of the
+// command whose response is being written.
+const pipelineDepth = 16
+
+// pipelineJob is a command running ahead of its turn. Its response is
+// buffered until all earlier responses have been written.
+type pipelineJob struct {
+	buf  bytes.Buffer
+	err  error
+	done chan struct{}
+}
+
+// pipelined reports whether cmd may run concurrently with the commands
+// around it. Commands which read stdin, change the session state or
+// change the remote (push, which must not overlap a list) run alone.
+func pipelined(cmd Command) bool {
+	switch cmd.(type) {
+	case *CmdList, *CmdFetch:
+		return true
+	default:
+		return false
+	}
+}
+
+// runPipelined is the main loop of Config.Pipeline sessions. Pipelined
+// commands start as soon as they are read; their responses are written
+// in the order Git sent the commands.
+func (r *runner) runPipelined(ctx context.Context, cancel context.CancelFunc, commands <-chan Command) {
+	var (
+		pending sync.WaitGroup
+		jobs    = make(chan *pipelineJob, pipelineDepth)
+	)
+
+	go r.writeResponses(cancel, jobs, &pending)
+
+	defer func() {
+		if r.failed() {
+			cancel()
+		}
+		close(jobs)
+		pending.Wait()
+	}()
+
+	for {
+		select {
+
+		case <-ctx.Done():
+			if r.setError(ctx.Err()) {
+				return
+			}
+
+		case cmd, ok := <-commands:
+			if !ok || r.failed() {
+				return
+			}
+
+			if !pipelined(cmd) {
+				pending.Wait()
+				if r.failed() || r.setError(r.runCommand(ctx, cmd)) {
+					return
+				}
+				continue
+			}
+
+			job := &pipelineJob{done: make(chan struct{})}
+			fork := r.fork(&job.buf)
+			r.Options.resetBatch(cmd)
+
+			pending.Add(1)
+			jobs <- job
+
+			go func() {
+				defer close(job.done)
+				job.err = fork.runCommand(ctx, cmd)
+			}()
+
+		}
+	}
+}
+
+// writeResponses writes the buffered responses of jobs in order. The
+// first failure cancels the session and the remaining responses are
+// discarded.
+func (r *runner) writeResponses(cancel context.CancelFunc, jobs <-chan *pipelineJob, pending *sync.WaitGroup) {
+	for job := range jobs {
+		<-job.done
+
+		if !r.failed() {
+			err := job.err
+			if err == nil {
+				_, err = job.buf.WriteTo(r.bw)
+			}
+			if err == nil {
+				err = r.bw.Flush()
+			}
+			if r.setError(err) {
+				cancel()
+			}
+		}
+
+		pending.Done()
+	}
+}
+
+// fork returns a runner for a pipelined command which writes its response
+// to w instead of stdout.
+func (r *runner) fork(w *bytes.Buffer) *runner {
+	return &runner{
+		Config:   r.Config,
+		br:       r.br,
+		bw:       bufio.NewWriter(w),
+		caps:     r.caps,
+		released: r.released,
+	}
+}
+
+func (r *runner) failed() bool {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.err != nil
+}
@@ -0,0 +1,78 @@
+package gitremote
+
+import (
+	"bytes"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// slowListHelper answers list slowly and list for-push quickly, so that
+// pipelined responses would come out of order if they were not buffered.
+type slowListHelper struct {
+	BaseHelper
+	running int32
+	max     int32
+}
+
+func (h *slowListHelper) Capabilities() Capabilities {
+	return Capabilities{Mandatory: CapFetch, Optional: CapPush}
+}
+
+func (h *slowListHelper) List(ctx context.Context, cmd *CmdList) ([]ListRef, error) {
+	n := atomic.AddInt32(&h.running, 1)
+	defer atomic.AddInt32(&h.running, -1)
+	for {
+		max := atomic.LoadInt32(&h.max)
+		if n <= max || atomic.CompareAndSwapInt32(&h.max, max, n) {
+			break
+		}
+	}
+
+	name, delay := "refs/heads/slow", 100*time.Millisecond
+	if cmd.ForPush {
+		name, delay = "refs/heads/fast", time.Millisecond
+	}
+	time.Sleep(delay)
+
+	return []ListRef{{Name: name, Hash: SHA1.ZeroHash()}}, nil
+}
+
+func (h *slowListHelper) Push(ctx context.Context, cmd *CmdPush) error {
+	for _, ref := range cmd.Refs {
+		ref.SetStatus(PushOk, nil)
+	}
+	return nil
+}
+
+func TestPipeline(t *testing.T) {
+	in := "list\nlist for-push\npush refs/heads/a:refs/heads/a\n\nlist for-push\n\n"
+
+	for _, pipeline := range []bool{false, true} {
+		var (
+			out bytes.Buffer
+			h   = &slowListHelper{}
+		)
+
+		err := Run(context.Background(), Config{Helper: h, Stdin: strings.NewReader(in), Stdout: &out, Pipeline: pipeline})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := SHA1.ZeroHash() + " refs/heads/slow\n\n" +
+			SHA1.ZeroHash() + " refs/heads/fast\n\n" +
+			"ok refs/heads/a\n\n" +
+			SHA1.ZeroHash() + " refs/heads/fast\n\n"
+		if out.String() != want {
+			t.Errorf("pipeline=%v: got %q, want %q", pipeline, out.String(), want)
+		}
+
+		concurrent := atomic.LoadInt32(&h.max) > 1
+		if concurrent != pipeline {
+			t.Errorf("pipeline=%v: concurrent lists = %v", pipeline, concurrent)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+// Package pktline implements the pkt-line framing used by the Git wire
+// protocol, as spoken over connect and stateless-connect sessions.
+package pktline
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	// MaxPacketLen is the maximum length of a pkt-line including the
+	// 4 byte length prefix.
+	MaxPacketLen = 65520
+
+	// MaxPayloadLen is the maximum length of the payload of a pkt-line.
+	MaxPayloadLen = MaxPacketLen - 4
+)
+
+var ErrTooLong = errors.New("pkt-line: payload too long")
+
+type ErrInvalidLength string
+
+func (e ErrInvalidLength) Error() string {
+	return fmt.Sprintf("pkt-line: invalid length: %q", string(e))
+}
+
+type PacketType int
+
+const (
+	Data        PacketType = iota // regular pkt-line with a payload
+	Flush                         // 0000 flush-pkt
+	Delim                         // 0001 delim-pkt (protocol v2)
+	ResponseEnd                   // 0002 response-end-pkt (stateless-connect)
+)
+
+func (t PacketType) String() string {
+	switch t {
+	case Data:
+		return "data-pkt"
+	case Flush:
+		return "flush-pkt"
+	case Delim:
+		return "delim-pkt"
+	case ResponseEnd:
+		return "response-end-pkt"
+	default:
+		return fmt.Sprintf("PacketType(%d)", int(t))
+	}
+}
+
+type Packet struct {
+	Type    PacketType
+	Payload []byte
+}
+
+// Reader reads pkt-lines from an underlying reader. It never reads past the
+// end of the last packet returned, so the underlying reader can be shared
+// with other consumers (like a packfile reader).
+type Reader struct {
+	r    io.Reader
+	head [4]byte
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: r}
+}
+
+// ReadPacket reads the next packet. io.EOF is returned only when the
+// underlying reader is at EOF before the first byte of a packet.
+func (r *Reader) ReadPacket() (Packet, error) {
+	_, err := io.ReadFull(r.r, r.head[:])
+	if err != nil {
+		return Packet{}, err
+	}
+
+	size, err := strconv.ParseUint(string(r.head[:]), 16, 16)
+	if err != nil {
+		return Packet{}, ErrInvalidLength(r.head[:])
+	}
+
+	switch size {
+	case 0:
+		return Packet{Type: Flush}, nil
+	case 1:
+		return Packet{Type: Delim}, nil
+	case 2:
+		return Packet{Type: ResponseEnd}, nil
+	case 3:
+		return Packet{}, ErrInvalidLength(r.head[:])
+	}
+
+	if size > MaxPacketLen {
+		return Packet{}, ErrInvalidLength(r.head[:])
+	}
+
+	payload := make([]byte, size-4)
+	_, err = io.ReadFull(r.r, payload)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return Packet{}, err
+	}
+
+	return Packet{Type: Data, Payload: payload}, nil
+}
+
+// ReadLine reads the next packet and returns its payload as a string with
+// the trailing newline removed. For special packets the line is empty.
+func (r *Reader) ReadLine() (string, PacketType, error) {
+	p, err := r.ReadPacket()
+	if err != nil {
+		return "", Data, err
+	}
+
+	return strings.TrimSuffix(string(p.Payload), "\n"), p.Type, nil
+}
+
+// ReadLines reads lines until a special packet is encountered and returns
+// the lines and the type of the terminating packet.
+func (r *Reader) ReadLines() ([]string, PacketType, error) {
+	var lines []string
+
+	for {
+		line, typ, err := r.ReadLine()
+		if err == io.EOF && len(lines) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, Data, err
+		}
+
+		if typ != Data {
+			return lines, typ, nil
+		}
+
+		lines = append(lines, line)
+	}
+}
+
+// Writer writes pkt-lines to an underlying writer.
+type Writer struct {
+	w io.Writer
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+func (w *Writer) WritePacket(payload []byte) error {
+	if len(payload) > MaxPayloadLen {
+		return ErrTooLong
+	}
+
+	_, err := fmt.Fprintf(w.w, "%04x", len(payload)+4)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.w.Write(payload)
+	return err
+}
+
+// WriteLine writes s as a single packet, adding a trailing newline when
+// it is missing.
+func (w *Writer) WriteLine(s string) error {
+	if !strings.HasSuffix(s, "\n") {
+		s += "\n"
+	}
+	return w.WritePacket([]byte(s))
+}
+
+func (w *Writer) Writef(format string, args ...interface{}) error {
+	return w.WriteLine(fmt.Sprintf(format, args...))
+}
+
+// WriteLines writes each line followed by the special packet typ.
+func (w *Writer) WriteLines(lines []string, typ PacketType) error {
+	for _, line := range lines {
+		err := w.WriteLine(line)
+		if err != nil {
+			return err
+		}
+	}
+
+	return w.WriteSpecial(typ)
+}
+
+func (w *Writer) WriteSpecial(typ PacketType) error {
+	var s string
+
+	switch typ {
+	case Flush:
+		s = "0000"
+	case Delim:
+		s = "0001"
+	case ResponseEnd:
+		s = "0002"
+	default:
+		return fmt.Errorf("pkt-line: %s is not a special packet", typ)
+	}
+
+	_, err := io.WriteString(w.w, s)
+	return err
+}
+
+func (w *Writer) WriteFlush() error       { return w.WriteSpecial(Flush) }
+func (w *Writer) WriteDelim() error       { return w.WriteSpecial(Delim) }
+func (w *Writer) WriteResponseEnd() error { return w.WriteSpecial(ResponseEnd) }
@@ -0,0 +1,144 @@
+package pktline
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(w *Writer) error
+		want  string
+	}{
+		{"line", func(w *Writer) error { return w.WriteLine("hello") }, "000ahello\n"},
+		{"line with newline", func(w *Writer) error { return w.WriteLine("hello\n") }, "000ahello\n"},
+		{"formatted", func(w *Writer) error { return w.Writef("want %s", "abc") }, "000dwant abc\n"},
+		{"raw packet", func(w *Writer) error { return w.WritePacket([]byte("a\x00b")) }, "0007a\x00b"},
+		{"empty packet", func(w *Writer) error { return w.WritePacket(nil) }, "0004"},
+		{"flush", (*Writer).WriteFlush, "0000"},
+		{"delim", (*Writer).WriteDelim, "0001"},
+		{"response end", (*Writer).WriteResponseEnd, "0002"},
+		{"lines", func(w *Writer) error { return w.WriteLines([]string{"a", "b"}, Delim) }, "0006a\n0006b\n0001"},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+		err := test.write(NewWriter(&buf))
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if buf.String() != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, buf.String(), test.want)
+		}
+	}
+}
+
+func TestWriterErrors(t *testing.T) {
+	w := NewWriter(ioutil.Discard)
+
+	if err := w.WritePacket(make([]byte, MaxPayloadLen+1)); err != ErrTooLong {
+		t.Errorf("oversized packet: got %v, want ErrTooLong", err)
+	}
+	if err := w.WritePacket(make([]byte, MaxPayloadLen)); err != nil {
+		t.Errorf("maximum packet: %s", err)
+	}
+	if err := w.WriteSpecial(Data); err == nil {
+		t.Error("WriteSpecial(Data) should fail")
+	}
+}
+
+func TestReader(t *testing.T) {
+	r := NewReader(strings.NewReader("000ahello\n0004000000010002" + "0006x\n"))
+
+	want := []Packet{
+		{Type: Data, Payload: []byte("hello\n")},
+		{Type: Data, Payload: []byte{}},
+		{Type: Flush},
+		{Type: Delim},
+		{Type: ResponseEnd},
+		{Type: Data, Payload: []byte("x\n")},
+	}
+
+	for i, w := range want {
+		p, err := r.ReadPacket()
+		if err != nil {
+			t.Fatalf("packet %d: %s", i, err)
+		}
+		if !reflect.DeepEqual(p, w) {
+			t.Errorf("packet %d: got %+v, want %+v", i, p, w)
+		}
+	}
+
+	_, err := r.ReadPacket()
+	if err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		err   error
+	}{
+		{"not hex", "zzzz", ErrInvalidLength("zzzz")},
+		{"length 3", "0003", ErrInvalidLength("0003")},
+		{"too long", "fff1", ErrInvalidLength("fff1")},
+		{"truncated length", "00", io.ErrUnexpectedEOF},
+		{"truncated payload", "000ahel", io.ErrUnexpectedEOF},
+	}
+
+	for _, test := range tests {
+		_, err := NewReader(strings.NewReader(test.input)).ReadPacket()
+		if err != test.err {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.err)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+
+	w.WriteLines([]string{"command=ls-refs", "agent=test"}, Delim)
+	w.WriteLines([]string{"peel", "symrefs"}, Flush)
+	w.WriteLines(nil, ResponseEnd)
+
+	r := NewReader(&buf)
+	tests := []struct {
+		lines []string
+		typ   PacketType
+	}{
+		{[]string{"command=ls-refs", "agent=test"}, Delim},
+		{[]string{"peel", "symrefs"}, Flush},
+		{nil, ResponseEnd},
+	}
+
+	for i, test := range tests {
+		lines, typ, err := r.ReadLines()
+		if err != nil {
+			t.Fatalf("group %d: %s", i, err)
+		}
+		if !reflect.DeepEqual(lines, test.lines) || typ != test.typ {
+			t.Errorf("group %d: got %q %s, want %q %s", i, lines, typ, test.lines, test.typ)
+		}
+	}
+
+	_, _, err := r.ReadLines()
+	if err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestReadLinesUnexpectedEOF(t *testing.T) {
+	_, _, err := NewReader(strings.NewReader("0006a\n")).ReadLines()
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("got %v, want io.ErrUnexpectedEOF", err)
+	}
+}
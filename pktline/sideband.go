@@ -0,0 +1,135 @@
+package pktline
+
+import (
+	"fmt"
+	"io"
+)
+
+const (
+	BandData     = 1
+	BandProgress = 2
+	BandError    = 3
+)
+
+const (
+	// MaxSidebandLen is the maximum payload length of side-band packets.
+	MaxSidebandLen = 1000 - 4 - 1
+
+	// MaxSideband64kLen is the maximum payload length of side-band-64k
+	// packets.
+	MaxSideband64kLen = MaxPayloadLen - 1
+)
+
+// ErrRemote is returned by a SidebandReader when the remote sent a message
+// on the error band.
+type ErrRemote string
+
+func (e ErrRemote) Error() string {
+	return fmt.Sprintf("remote error: %s", string(e))
+}
+
+// SidebandReader demultiplexes a side-band(-64k) stream. Read returns the
+// contents of the data band. Progress messages are copied to Progress
+// (when not nil). The stream ends with io.EOF at the flush-pkt.
+type SidebandReader struct {
+	r        *Reader
+	progress io.Writer
+	buf      []byte
+	err      error
+}
+
+func NewSidebandReader(r *Reader, progress io.Writer) *SidebandReader {
+	return &SidebandReader{r: r, progress: progress}
+}
+
+func (s *SidebandReader) Read(p []byte) (int, error) {
+	for len(s.buf) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+
+		pkt, err := s.r.ReadPacket()
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			s.err = err
+			continue
+		}
+
+		if pkt.Type != Data {
+			s.err = io.EOF
+			continue
+		}
+
+		if len(pkt.Payload) == 0 {
+			continue
+		}
+
+		band, payload := pkt.Payload[0], pkt.Payload[1:]
+		switch band {
+		case BandData:
+			s.buf = payload
+		case BandProgress:
+			if s.progress != nil {
+				_, err = s.progress.Write(payload)
+				if err != nil {
+					s.err = err
+				}
+			}
+		case BandError:
+			s.err = ErrRemote(payload)
+		default:
+			s.err = fmt.Errorf("pkt-line: invalid side-band %d", band)
+		}
+	}
+
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+// SidebandWriter writes data on a single band, splitting it into packets of
+// at most max bytes.
+type SidebandWriter struct {
+	w    *Writer
+	band byte
+	max  int
+}
+
+// NewSidebandWriter returns a writer for band. When large is true packets
+// are sized for side-band-64k, otherwise for side-band.
+func NewSidebandWriter(w *Writer, band byte, large bool) *SidebandWriter {
+	max := MaxSidebandLen
+	if large {
+		max = MaxSideband64kLen
+	}
+	return &SidebandWriter{w: w, band: band, max: max}
+}
+
+func (s *SidebandWriter) Write(p []byte) (int, error) {
+	var (
+		n   int
+		buf = make([]byte, 0, s.max+1)
+	)
+
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > s.max {
+			chunk = chunk[:s.max]
+		}
+
+		buf = append(buf[:0], s.band)
+		buf = append(buf, chunk...)
+
+		err := s.w.WritePacket(buf)
+		if err != nil {
+			return n, err
+		}
+
+		n += len(chunk)
+		p = p[len(chunk):]
+	}
+
+	return n, nil
+}
@@ -0,0 +1,69 @@
+package pktline
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestSidebandRoundTrip(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 300)
+
+	for _, large := range []bool{false, true} {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+
+		NewSidebandWriter(w, BandProgress, large).Write([]byte("Counting objects: 1\r"))
+		n, err := NewSidebandWriter(w, BandData, large).Write(data)
+		if err != nil || n != len(data) {
+			t.Fatalf("large=%v: write: %d, %v", large, n, err)
+		}
+		w.WriteFlush()
+
+		// the side-band packet size limit is honoured
+		r := NewReader(bytes.NewReader(buf.Bytes()))
+		for {
+			p, err := r.ReadPacket()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Type != Data {
+				break
+			}
+			if !large && len(p.Payload) > MaxSidebandLen+1 {
+				t.Errorf("side-band packet of %d bytes", len(p.Payload))
+			}
+		}
+
+		var progress bytes.Buffer
+		got, err := ioutil.ReadAll(NewSidebandReader(NewReader(&buf), &progress))
+		if err != nil {
+			t.Fatalf("large=%v: read: %s", large, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("large=%v: data mismatch", large)
+		}
+		if progress.String() != "Counting objects: 1\r" {
+			t.Errorf("large=%v: progress: got %q", large, progress.String())
+		}
+	}
+}
+
+func TestSidebandErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		err   error
+	}{
+		{"error band", "0009\x03oops0000", ErrRemote("oops")},
+		{"missing flush", "0006\x01a", io.ErrUnexpectedEOF},
+	}
+
+	for _, test := range tests {
+		_, err := ioutil.ReadAll(NewSidebandReader(NewReader(bytes.NewBufferString(test.input)), nil))
+		if err != test.err {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,416 @@
+package pktline
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Helpers for the protocol v0/v1 conversations of git-upload-pack and
+// git-receive-pack.
+
+type ErrUnexpectedLine string
+
+func (e ErrUnexpectedLine) Error() string {
+	return fmt.Sprintf("pkt-line: unexpected line: %q", string(e))
+}
+
+type Ref struct {
+	Hash string
+	Name string
+}
+
+// Advertisement is the reference advertisement sent by upload-pack and
+// receive-pack at the start of a v0/v1 conversation.
+type Advertisement struct {
+	Version      int // 0 or 1
+	Refs         []Ref
+	Capabilities []string
+	Shallows     []string
+}
+
+// ReadAdvertisement reads a reference advertisement. A leading smart HTTP
+// "# service=..." section is skipped.
+func ReadAdvertisement(r *Reader) (*Advertisement, error) {
+	lines, _, err := r.ReadLines()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(lines) > 0 && strings.HasPrefix(lines[0], "# service=") {
+		lines, _, err = r.ReadLines()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	adv := &Advertisement{}
+
+	if len(lines) > 0 && strings.HasPrefix(lines[0], "version ") {
+		adv.Version, err = strconv.Atoi(strings.TrimPrefix(lines[0], "version "))
+		if err != nil {
+			return nil, ErrUnexpectedLine(lines[0])
+		}
+		lines = lines[1:]
+	}
+
+	for i, line := range lines {
+		if i == 0 {
+			if idx := strings.IndexByte(line, 0); idx >= 0 {
+				adv.Capabilities = strings.Fields(line[idx+1:])
+				line = line[:idx]
+			}
+		}
+
+		if strings.HasPrefix(line, "shallow ") {
+			adv.Shallows = append(adv.Shallows, strings.TrimPrefix(line, "shallow "))
+			continue
+		}
+
+		parts := strings.SplitN(line, " ", 2)
+		if len(parts) != 2 {
+			return nil, ErrUnexpectedLine(line)
+		}
+
+		if i == 0 && parts[1] == "capabilities^{}" {
+			// empty repository
+			continue
+		}
+
+		adv.Refs = append(adv.Refs, Ref{Hash: parts[0], Name: parts[1]})
+	}
+
+	return adv, nil
+}
+
+// WriteAdvertisement writes a reference advertisement. zeroHash is used
+// to advertise the capabilities of an empty repository.
+func WriteAdvertisement(w *Writer, adv *Advertisement, zeroHash string) error {
+	var lines []string
+
+	if adv.Version > 0 {
+		lines = append(lines, fmt.Sprintf("version %d", adv.Version))
+	}
+
+	caps := "\x00" + strings.Join(adv.Capabilities, " ")
+
+	if len(adv.Refs) == 0 {
+		lines = append(lines, zeroHash+" capabilities^{}"+caps)
+	}
+
+	for i, ref := range adv.Refs {
+		line := ref.Hash + " " + ref.Name
+		if i == 0 {
+			line += caps
+		}
+		lines = append(lines, line)
+	}
+
+	for _, hash := range adv.Shallows {
+		lines = append(lines, "shallow "+hash)
+	}
+
+	return w.WriteLines(lines, Flush)
+}
+
+// UploadRequest is the first section of the request a client sends to
+// upload-pack.
+type UploadRequest struct {
+	Wants        []string
+	Capabilities []string
+	Shallows     []string
+	Depth        int
+	DeepenSince  string
+	DeepenNot    []string
+	Filter       string
+}
+
+// ReadUploadRequest reads the wants section of an upload-pack request up to
+// and including the flush-pkt. A request without wants means the client
+// does not need anything.
+func ReadUploadRequest(r *Reader) (*UploadRequest, error) {
+	lines, _, err := r.ReadLines()
+	if err != nil {
+		return nil, err
+	}
+
+	req := &UploadRequest{}
+
+	for _, line := range lines {
+		switch {
+
+		case strings.HasPrefix(line, "want "):
+			parts := strings.Fields(strings.TrimPrefix(line, "want "))
+			if len(parts) == 0 {
+				return nil, ErrUnexpectedLine(line)
+			}
+			if len(req.Wants) == 0 {
+				req.Capabilities = parts[1:]
+			}
+			req.Wants = append(req.Wants, parts[0])
+
+		case strings.HasPrefix(line, "shallow "):
+			req.Shallows = append(req.Shallows, strings.TrimPrefix(line, "shallow "))
+
+		case strings.HasPrefix(line, "deepen "):
+			req.Depth, err = strconv.Atoi(strings.TrimPrefix(line, "deepen "))
+			if err != nil {
+				return nil, ErrUnexpectedLine(line)
+			}
+
+		case strings.HasPrefix(line, "deepen-since "):
+			req.DeepenSince = strings.TrimPrefix(line, "deepen-since ")
+
+		case strings.HasPrefix(line, "deepen-not "):
+			req.DeepenNot = append(req.DeepenNot, strings.TrimPrefix(line, "deepen-not "))
+
+		case strings.HasPrefix(line, "filter "):
+			req.Filter = strings.TrimPrefix(line, "filter ")
+
+		default:
+			return nil, ErrUnexpectedLine(line)
+
+		}
+	}
+
+	return req, nil
+}
+
+func WriteUploadRequest(w *Writer, req *UploadRequest) error {
+	var lines []string
+
+	for i, want := range req.Wants {
+		line := "want " + want
+		if i == 0 && len(req.Capabilities) > 0 {
+			line += " " + strings.Join(req.Capabilities, " ")
+		}
+		lines = append(lines, line)
+	}
+
+	for _, hash := range req.Shallows {
+		lines = append(lines, "shallow "+hash)
+	}
+	if req.Depth > 0 {
+		lines = append(lines, fmt.Sprintf("deepen %d", req.Depth))
+	}
+	if req.DeepenSince != "" {
+		lines = append(lines, "deepen-since "+req.DeepenSince)
+	}
+	for _, ref := range req.DeepenNot {
+		lines = append(lines, "deepen-not "+ref)
+	}
+	if req.Filter != "" {
+		lines = append(lines, "filter "+req.Filter)
+	}
+
+	return w.WriteLines(lines, Flush)
+}
+
+// ReadHaves reads a single negotiation round. done is true when the client
+// sent "done" instead of a flush-pkt.
+func ReadHaves(r *Reader) (haves []string, done bool, err error) {
+	for {
+		line, typ, err := r.ReadLine()
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, false, err
+		}
+
+		switch {
+		case typ == Flush:
+			return haves, false, nil
+		case typ != Data:
+			return nil, false, fmt.Errorf("pkt-line: unexpected %s", typ)
+		case line == "done":
+			return haves, true, nil
+		case strings.HasPrefix(line, "have "):
+			haves = append(haves, strings.TrimPrefix(line, "have "))
+		default:
+			return nil, false, ErrUnexpectedLine(line)
+		}
+	}
+}
+
+// WriteHaves writes a single negotiation round, terminated by "done" when
+// done is true and by a flush-pkt otherwise.
+func WriteHaves(w *Writer, haves []string, done bool) error {
+	for _, hash := range haves {
+		err := w.WriteLine("have " + hash)
+		if err != nil {
+			return err
+		}
+	}
+
+	if done {
+		return w.WriteLine("done")
+	}
+	return w.WriteFlush()
+}
+
+// Ack is an acknowledgement sent by upload-pack during negotiation. A NAK
+// is represented by an Ack with an empty Hash.
+type Ack struct {
+	Hash   string
+	Status string // "", "continue", "common" or "ready"
+}
+
+func ReadAck(r *Reader) (Ack, error) {
+	line, typ, err := r.ReadLine()
+	if err != nil {
+		return Ack{}, err
+	}
+	if typ != Data {
+		return Ack{}, fmt.Errorf("pkt-line: unexpected %s", typ)
+	}
+
+	if line == "NAK" {
+		return Ack{}, nil
+	}
+
+	parts := strings.Fields(line)
+	if len(parts) < 2 || len(parts) > 3 || parts[0] != "ACK" {
+		return Ack{}, ErrUnexpectedLine(line)
+	}
+
+	ack := Ack{Hash: parts[1]}
+	if len(parts) == 3 {
+		ack.Status = parts[2]
+	}
+	return ack, nil
+}
+
+func WriteAck(w *Writer, ack Ack) error {
+	if ack.Hash == "" {
+		return w.WriteLine("NAK")
+	}
+	if ack.Status == "" {
+		return w.WriteLine("ACK " + ack.Hash)
+	}
+	return w.WriteLine("ACK " + ack.Hash + " " + ack.Status)
+}
+
+// Command is a single ref update sent to receive-pack.
+type Command struct {
+	Old  string
+	New  string
+	Name string
+}
+
+// UpdateRequest is the command list a client sends to receive-pack. The
+// packfile (if any) follows the request on the same stream.
+type UpdateRequest struct {
+	Shallows     []string
+	Commands     []Command
+	Capabilities []string
+}
+
+func ReadUpdateRequest(r *Reader) (*UpdateRequest, error) {
+	lines, _, err := r.ReadLines()
+	if err != nil {
+		return nil, err
+	}
+
+	req := &UpdateRequest{}
+
+	for _, line := range lines {
+		if strings.HasPrefix(line, "shallow ") {
+			req.Shallows = append(req.Shallows, strings.TrimPrefix(line, "shallow "))
+			continue
+		}
+
+		if len(req.Commands) == 0 {
+			if idx := strings.IndexByte(line, 0); idx >= 0 {
+				req.Capabilities = strings.Fields(line[idx+1:])
+				line = line[:idx]
+			}
+		}
+
+		parts := strings.SplitN(line, " ", 3)
+		if len(parts) != 3 {
+			return nil, ErrUnexpectedLine(line)
+		}
+
+		req.Commands = append(req.Commands, Command{Old: parts[0], New: parts[1], Name: parts[2]})
+	}
+
+	return req, nil
+}
+
+func WriteUpdateRequest(w *Writer, req *UpdateRequest) error {
+	var lines []string
+
+	for _, hash := range req.Shallows {
+		lines = append(lines, "shallow "+hash)
+	}
+
+	for i, cmd := range req.Commands {
+		line := cmd.Old + " " + cmd.New + " " + cmd.Name
+		if i == 0 {
+			line += "\x00" + strings.Join(req.Capabilities, " ")
+		}
+		lines = append(lines, line)
+	}
+
+	return w.WriteLines(lines, Flush)
+}
+
+// CommandStatus is the result of a single ref update. Err is empty when the
+// update succeeded.
+type CommandStatus struct {
+	Name string
+	Err  string
+}
+
+// ReportStatus is the response of receive-pack when the report-status
+// capability was requested. Unpack is "ok" or an error message.
+type ReportStatus struct {
+	Unpack   string
+	Commands []CommandStatus
+}
+
+func ReadReportStatus(r *Reader) (*ReportStatus, error) {
+	lines, _, err := r.ReadLines()
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 || !strings.HasPrefix(lines[0], "unpack ") {
+		return nil, fmt.Errorf("pkt-line: missing unpack status")
+	}
+
+	rs := &ReportStatus{Unpack: strings.TrimPrefix(lines[0], "unpack ")}
+
+	for _, line := range lines[1:] {
+		switch {
+		case strings.HasPrefix(line, "ok "):
+			rs.Commands = append(rs.Commands, CommandStatus{Name: strings.TrimPrefix(line, "ok ")})
+		case strings.HasPrefix(line, "ng "):
+			parts := strings.SplitN(strings.TrimPrefix(line, "ng "), " ", 2)
+			status := CommandStatus{Name: parts[0], Err: "failed"}
+			if len(parts) == 2 {
+				status.Err = parts[1]
+			}
+			rs.Commands = append(rs.Commands, status)
+		default:
+			return nil, ErrUnexpectedLine(line)
+		}
+	}
+
+	return rs, nil
+}
+
+func WriteReportStatus(w *Writer, rs *ReportStatus) error {
+	lines := []string{"unpack " + rs.Unpack}
+
+	for _, status := range rs.Commands {
+		if status.Err == "" {
+			lines = append(lines, "ok "+status.Name)
+		} else {
+			lines = append(lines, "ng "+status.Name+" "+status.Err)
+		}
+	}
+
+	return w.WriteLines(lines, Flush)
+}
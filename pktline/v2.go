@@ -0,0 +1,169 @@
+package pktline
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Helpers for protocol v2 conversations.
+
+// ReadCapabilityAdvertisement reads a protocol v2 capability advertisement
+// ("version 2" followed by capabilities and a flush-pkt).
+func ReadCapabilityAdvertisement(r *Reader) ([]string, error) {
+	lines, _, err := r.ReadLines()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(lines) > 0 && strings.HasPrefix(lines[0], "# service=") {
+		lines, _, err = r.ReadLines()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(lines) == 0 || lines[0] != "version 2" {
+		return nil, fmt.Errorf("pkt-line: expected protocol version 2")
+	}
+
+	return lines[1:], nil
+}
+
+func WriteCapabilityAdvertisement(w *Writer, caps []string) error {
+	return w.WriteLines(append([]string{"version 2"}, caps...), Flush)
+}
+
+// CommandRequest is a protocol v2 command request:
+//
+//	command=<name>
+//	<capabilities>
+//	delim-pkt
+//	<arguments>
+//	flush-pkt
+type CommandRequest struct {
+	Command      string
+	Capabilities []string
+	Args         []string
+}
+
+// ReadCommandRequest reads a single command request. io.EOF is returned when
+// the client closed the connection between requests.
+func ReadCommandRequest(r *Reader) (*CommandRequest, error) {
+	lines, typ, err := r.ReadLines()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(lines) == 0 || !strings.HasPrefix(lines[0], "command=") {
+		return nil, fmt.Errorf("pkt-line: expected command")
+	}
+
+	req := &CommandRequest{
+		Command:      strings.TrimPrefix(lines[0], "command="),
+		Capabilities: lines[1:],
+	}
+
+	if typ == Delim {
+		req.Args, typ, err = r.ReadLines()
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if typ != Flush {
+		return nil, fmt.Errorf("pkt-line: unexpected %s", typ)
+	}
+
+	return req, nil
+}
+
+func WriteCommandRequest(w *Writer, req *CommandRequest) error {
+	lines := append([]string{"command=" + req.Command}, req.Capabilities...)
+
+	err := w.WriteLines(lines, Delim)
+	if err != nil {
+		return err
+	}
+
+	return w.WriteLines(req.Args, Flush)
+}
+
+// LsRef is a single ref in the response to the ls-refs command.
+type LsRef struct {
+	Hash         string // "unborn" for unborn symrefs
+	Name         string
+	SymrefTarget string
+	Peeled       string
+}
+
+// ReadLsRefs reads the response to the ls-refs command.
+func ReadLsRefs(r *Reader) ([]LsRef, error) {
+	lines, typ, err := r.ReadLines()
+	if err != nil {
+		return nil, err
+	}
+	if typ != Flush {
+		return nil, fmt.Errorf("pkt-line: unexpected %s", typ)
+	}
+
+	refs := make([]LsRef, 0, len(lines))
+
+	for _, line := range lines {
+		parts := strings.Split(line, " ")
+		if len(parts) < 2 {
+			return nil, ErrUnexpectedLine(line)
+		}
+
+		ref := LsRef{Hash: parts[0], Name: parts[1]}
+
+		for _, attr := range parts[2:] {
+			switch {
+			case strings.HasPrefix(attr, "symref-target:"):
+				ref.SymrefTarget = strings.TrimPrefix(attr, "symref-target:")
+			case strings.HasPrefix(attr, "peeled:"):
+				ref.Peeled = strings.TrimPrefix(attr, "peeled:")
+			}
+		}
+
+		refs = append(refs, ref)
+	}
+
+	return refs, nil
+}
+
+func WriteLsRefs(w *Writer, refs []LsRef) error {
+	lines := make([]string, 0, len(refs))
+
+	for _, ref := range refs {
+		line := ref.Hash + " " + ref.Name
+		if ref.SymrefTarget != "" {
+			line += " symref-target:" + ref.SymrefTarget
+		}
+		if ref.Peeled != "" {
+			line += " peeled:" + ref.Peeled
+		}
+		lines = append(lines, line)
+	}
+
+	return w.WriteLines(lines, Flush)
+}
+
+// ReadSectionHeader reads the header line of a section in the response
+// to the fetch command (acknowledgments, shallow-info, wanted-refs,
+// packfile-uris or packfile). The lines of the section can be read with
+// ReadLines; the packfile section is multiplexed and must be read with a
+// SidebandReader.
+func ReadSectionHeader(r *Reader) (string, error) {
+	line, typ, err := r.ReadLine()
+	if err != nil {
+		return "", err
+	}
+	if typ != Data {
+		return "", fmt.Errorf("pkt-line: unexpected %s", typ)
+	}
+	return line, nil
+}
@@ -0,0 +1,70 @@
+package pktline
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestCapabilityAdvertisement(t *testing.T) {
+	caps := []string{"agent=git/2.40", "ls-refs=unborn", "fetch=shallow"}
+
+	var buf bytes.Buffer
+	err := WriteCapabilityAdvertisement(NewWriter(&buf), caps)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ReadCapabilityAdvertisement(NewReader(&buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, caps) {
+		t.Errorf("got %q, want %q", got, caps)
+	}
+}
+
+func TestCommandRequest(t *testing.T) {
+	tests := []*CommandRequest{
+		{Command: "ls-refs", Capabilities: []string{"agent=test"}, Args: []string{"peel", "ref-prefix refs/heads/"}},
+		{Command: "fetch", Capabilities: []string{}},
+	}
+
+	for _, req := range tests {
+		var buf bytes.Buffer
+		err := WriteCommandRequest(NewWriter(&buf), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := ReadCommandRequest(NewReader(&buf))
+		if err != nil {
+			t.Fatalf("%s: %s", req.Command, err)
+		}
+		if !reflect.DeepEqual(got, req) {
+			t.Errorf("got %#v, want %#v", got, req)
+		}
+	}
+}
+
+func TestLsRefs(t *testing.T) {
+	refs := []LsRef{
+		{Hash: "0123456789abcdef0123456789abcdef01234567", Name: "refs/heads/master"},
+		{Hash: "unborn", Name: "HEAD", SymrefTarget: "refs/heads/main"},
+		{Hash: "89abcdef0123456789abcdef0123456789abcdef", Name: "refs/tags/v1", Peeled: "0123456789abcdef0123456789abcdef01234567"},
+	}
+
+	var buf bytes.Buffer
+	err := WriteLsRefs(NewWriter(&buf), refs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ReadLsRefs(NewReader(&buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, refs) {
+		t.Errorf("got %#v, want %#v", got, refs)
+	}
+}
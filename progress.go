@@ -0,0 +1,125 @@
+package gitremote
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// progressInterval is the minimum delay between two redraws of a meter.
+const progressInterval = 100 * time.Millisecond
+
+// Progress renders Git-style progress meters on stderr. It is silent when
+// Git set `option progress false`. A nil *Progress is valid and silent.
+//
+// Progress is safe for concurrent use; meters started from several
+// goroutines share the same output line.
+type Progress struct {
+	mtx     sync.Mutex
+	w       io.Writer
+	enabled bool
+}
+
+// NewProgress returns a Progress writing to w. Nothing is written unless
+// enabled is true.
+func NewProgress(w io.Writer, enabled bool) *Progress {
+	return &Progress{w: w, enabled: enabled && w != nil}
+}
+
+// Enabled reports whether meters are rendered.
+func (p *Progress) Enabled() bool {
+	return p != nil && p.enabled
+}
+
+// Start starts a meter with a title like "Receiving objects". When total
+// is zero the meter shows a plain counter instead of a percentage.
+func (p *Progress) Start(title string, total int64) *Meter {
+	if !p.Enabled() {
+		return nil
+	}
+	return &Meter{p: p, title: title, total: total}
+}
+
+// Meter is a single progress meter. A nil *Meter ignores all calls.
+type Meter struct {
+	p     *Progress
+	title string
+	total int64
+	n     int64
+	last  time.Time
+	done  bool
+}
+
+// Add advances the meter by n.
+func (m *Meter) Add(n int64) {
+	if m == nil {
+		return
+	}
+
+	m.p.mtx.Lock()
+	defer m.p.mtx.Unlock()
+
+	m.n += n
+	m.update(false)
+}
+
+// Set moves the meter to n.
+func (m *Meter) Set(n int64) {
+	if m == nil {
+		return
+	}
+
+	m.p.mtx.Lock()
+	defer m.p.mtx.Unlock()
+
+	m.n = n
+	m.update(false)
+}
+
+// Done draws the final state of the meter and ends its line.
+func (m *Meter) Done() {
+	if m == nil {
+		return
+	}
+
+	m.p.mtx.Lock()
+	defer m.p.mtx.Unlock()
+
+	m.update(true)
+}
+
+func (m *Meter) update(done bool) {
+	if m.done {
+		return
+	}
+
+	now := time.Now()
+	if !done && now.Sub(m.last) < progressInterval {
+		return
+	}
+	m.last = now
+	m.done = done
+
+	fmt.Fprint(m.p.w, m.String())
+}
+
+// String returns the meter line as Git renders it, including the leading
+// carriage return.
+func (m *Meter) String() string {
+	if m == nil {
+		return ""
+	}
+
+	eol := ""
+	if m.done {
+		eol = ", done.\n"
+	}
+
+	if m.total <= 0 {
+		return fmt.Sprintf("\r%s: %d%s", m.title, m.n, eol)
+	}
+
+	percent := m.n * 100 / m.total
+	return fmt.Sprintf("\r%s: %3d%% (%d/%d)%s", m.title, percent, m.n, m.total, eol)
+}
@@ -0,0 +1,119 @@
+package gitremote
+
+import (
+	"fmt"
+
+	"github.com/fd/go-git-remote-helper/fastimport"
+)
+
+// PushStatus is the outcome of a single ref update. Git turns it into the
+// human readable messages printed by git push.
+type PushStatus int
+
+const (
+	// PushStatusUnset derives the status from PushRef.Ok and PushRef.Err.
+	PushStatusUnset PushStatus = iota
+
+	PushOk       // ok <dst>
+	PushForced   // ok <dst> forced update
+	PushUpToDate // ok <dst> up to date
+	PushDeleted  // ok <dst>
+
+	PushRejectedNonFastForward // error <dst> non-fast forward (sic, as Git expects)
+	PushRejectedAlreadyExists  // error <dst> already exists
+	PushRejectedFetchFirst     // error <dst> fetch first
+	PushRejectedNeedsForce     // error <dst> needs force
+	PushRejectedStale          // error <dst> stale info
+	PushRejectedHook           // error <dst> <Err or "hook declined">
+	PushRemoteError            // error <dst> <Err>
+)
+
+func (s PushStatus) String() string {
+	switch s {
+	case PushStatusUnset:
+		return "unset"
+	case PushOk:
+		return "ok"
+	case PushForced:
+		return "forced update"
+	case PushUpToDate:
+		return "up to date"
+	case PushDeleted:
+		return "deleted"
+	case PushRejectedNonFastForward:
+		return "non-fast forward"
+	case PushRejectedAlreadyExists:
+		return "already exists"
+	case PushRejectedFetchFirst:
+		return "fetch first"
+	case PushRejectedNeedsForce:
+		return "needs force"
+	case PushRejectedStale:
+		return "stale info"
+	case PushRejectedHook:
+		return "hook declined"
+	case PushRemoteError:
+		return "remote error"
+	default:
+		return fmt.Sprintf("PushStatus(%d)", int(s))
+	}
+}
+
+// IsOk returns true when the ref was updated (or did not need updating).
+func (s PushStatus) IsOk() bool {
+	switch s {
+	case PushOk, PushForced, PushUpToDate, PushDeleted:
+		return true
+	default:
+		return false
+	}
+}
+
+// status returns the effective status of ref.
+func (ref *PushRef) status() PushStatus {
+	if ref.Status != PushStatusUnset {
+		return ref.Status
+	}
+	if ref.Ok {
+		return PushOk
+	}
+	return PushRemoteError
+}
+
+// SetStatus sets the status of ref. err is the message reported for
+// PushRejectedHook and PushRemoteError and may be nil.
+func (ref *PushRef) SetStatus(status PushStatus, err error) {
+	ref.Status = status
+	ref.Ok = status.IsOk()
+	ref.Err = err
+}
+
+// statusLine returns the line reported to Git (without the newline).
+func (ref *PushRef) statusLine() string {
+	status := ref.status()
+
+	switch status {
+	case PushOk, PushDeleted:
+		return "ok " + ref.Dst
+	case PushForced, PushUpToDate:
+		return "ok " + ref.Dst + " " + status.String()
+	case PushRejectedHook:
+		msg := status.String()
+		if ref.Err != nil {
+			msg = ref.Err.Error()
+		}
+		return "error " + ref.Dst + " " + quoteMessage(msg)
+	case PushRemoteError:
+		if ref.Err == nil {
+			return "error " + ref.Dst
+		}
+		return "error " + ref.Dst + " " + quoteMessage(ref.Err.Error())
+	default:
+		return "error " + ref.Dst + " " + status.String()
+	}
+}
+
+// quoteMessage C-quotes messages which Git would otherwise misread.
+func quoteMessage(msg string) string {
+	return fastimport.QuotePath(msg, false)
+}
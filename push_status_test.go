@@ -0,0 +1,60 @@
+package gitremote
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPushStatusLine(t *testing.T) {
+	tests := []struct {
+		status PushStatus
+		err    error
+		want   string
+	}{
+		{PushOk, nil, "ok refs/heads/x"},
+		{PushDeleted, nil, "ok refs/heads/x"},
+		{PushForced, nil, "ok refs/heads/x forced update"},
+		{PushUpToDate, nil, "ok refs/heads/x up to date"},
+		{PushRejectedNonFastForward, nil, "error refs/heads/x non-fast forward"},
+		{PushRejectedAlreadyExists, nil, "error refs/heads/x already exists"},
+		{PushRejectedFetchFirst, nil, "error refs/heads/x fetch first"},
+		{PushRejectedNeedsForce, nil, "error refs/heads/x needs force"},
+		{PushRejectedStale, nil, "error refs/heads/x stale info"},
+		{PushRejectedHook, nil, "error refs/heads/x hook declined"},
+		{PushRejectedHook, errors.New("pre-receive said no"), "error refs/heads/x pre-receive said no"},
+		{PushRemoteError, nil, "error refs/heads/x"},
+		{PushRemoteError, errors.New("disk full"), "error refs/heads/x disk full"},
+		{PushRemoteError, errors.New("two\nlines"), `error refs/heads/x "two\nlines"`},
+	}
+
+	for _, test := range tests {
+		ref := &PushRef{Dst: "refs/heads/x"}
+		ref.SetStatus(test.status, test.err)
+
+		got := ref.statusLine()
+		if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.status, got, test.want)
+		}
+		if ref.Ok != test.status.IsOk() {
+			t.Errorf("%s: Ok = %v", test.status, ref.Ok)
+		}
+	}
+}
+
+func TestPushStatusUnset(t *testing.T) {
+	tests := []struct {
+		ref  PushRef
+		want string
+	}{
+		{PushRef{Dst: "refs/heads/x", Ok: true}, "ok refs/heads/x"},
+		{PushRef{Dst: "refs/heads/x"}, "error refs/heads/x"},
+		{PushRef{Dst: "refs/heads/x", Err: errors.New("nope")}, "error refs/heads/x nope"},
+	}
+
+	for _, test := range tests {
+		got := test.ref.statusLine()
+		if got != test.want {
+			t.Errorf("got %q, want %q", got, test.want)
+		}
+	}
+}
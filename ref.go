@@ -2,17 +2,38 @@ package gitremote
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"io"
+	"strings"
 )
 
 type ListRef struct {
 	Name string
 
-	Hash string // sha1 hash  value
+	Hash string // object id in the negotiated object format
 	Sym  string // @<dest> for symref
 	// When Hash and Sym are blank the <value> is '?'
 
-	Unchanged bool // unchanged attribute
+	// Unknown forces the <value> to '?', telling Git the value is not
+	// known and must be fetched (or, for list for-push, that the ref
+	// must be treated as changed).
+	Unknown bool
+
+	Unchanged bool     // unchanged attribute
+	Attrs     []string // additional attributes
+}
+
+// ListKeyword is a ":<name> <value>" line leading the list response.
+type ListKeyword struct {
+	Name  string
+	Value string
+}
+
+type ErrInvalidListEntry string
+
+func (e ErrInvalidListEntry) Error() string {
+	return fmt.Sprintf("invalid list entry: %q", string(e))
 }
 
 type PushRef struct {
@@ -20,13 +41,115 @@ type PushRef struct {
 	Dst   string
 	Force bool
 
+	// Expect is the value Dst must have on the remote for the update to
+	// proceed (git push --force-with-lease). It is empty when there is no
+	// expectation; an all-zero hash means Dst must not exist.
+	Expect string
+
+	// Status is the detailed outcome of the update. When it is not set
+	// the outcome is derived from Ok and Err.
+	Status PushStatus
+
 	Ok  bool
 	Err error
 }
 
+// ErrStaleInfo is the error Git expects when the expected value of a
+// ref (see PushRef.Expect) does not match the remote value.
+var ErrStaleInfo = errors.New("stale info")
+
+// CheckExpect compares the expected value of ref with current, the value
+// of the ref on the remote ("" when the ref does not exist). When they do
+// not match the ref is rejected with ErrStaleInfo and false is returned.
+func (ref *PushRef) CheckExpect(current string) bool {
+	if ref.Expect == "" {
+		return true
+	}
+
+	if ref.Expect == current || (isZeroHash(ref.Expect) && (current == "" || isZeroHash(current))) {
+		return true
+	}
+
+	ref.SetStatus(PushRejectedStale, ErrStaleInfo)
+	return false
+}
+
+func isZeroHash(hash string) bool {
+	return strings.Trim(hash, "0") == ""
+}
+
+// attachExpectations sets PushRef.Expect from the values of the cas
+// option (<ref>:<expected>). An empty expected value means the ref must not
+// exist and is stored as the zero hash of format.
+func attachExpectations(refs []*PushRef, cas []string, format ObjectFormat) {
+	for _, v := range cas {
+		idx := strings.IndexByte(v, ':')
+		if idx < 0 {
+			continue
+		}
+
+		name, expect := v[:idx], v[idx+1:]
+		if expect == "" {
+			expect = format.ZeroHash()
+		}
+		for _, ref := range refs {
+			if ref.Dst == name {
+				ref.Expect = expect
+			}
+		}
+	}
+}
+
+// ErrAtomicPushFailed is reported for refs which could have been updated
+// but were rejected because another ref of an atomic push failed.
+var ErrAtomicPushFailed = errors.New("atomic push failure")
+
+// rejectAllUnlessOk rejects every ref when any single ref failed.
+func rejectAllUnlessOk(refs []*PushRef) {
+	var failed bool
+
+	for _, ref := range refs {
+		if !ref.status().IsOk() {
+			failed = true
+			break
+		}
+	}
+
+	if !failed {
+		return
+	}
+
+	for _, ref := range refs {
+		if ref.status().IsOk() {
+			ref.SetStatus(PushRemoteError, ErrAtomicPushFailed)
+		}
+	}
+}
+
 type listRefSlice []ListRef
 
-func (r listRefSlice) writeTo(w io.Writer) error {
+func (r listRefSlice) validate(keywords []ListKeyword) error {
+	for _, kw := range keywords {
+		if kw.Name == "" || strings.ContainsAny(kw.Name, " \n") || strings.ContainsRune(kw.Value, '\n') {
+			return ErrInvalidListEntry(":" + kw.Name + " " + kw.Value)
+		}
+	}
+
+	for _, ref := range r {
+		if ref.Name == "" || strings.ContainsAny(ref.Name, " \n") {
+			return ErrInvalidListEntry(ref.Name)
+		}
+		for _, attr := range ref.Attrs {
+			if attr == "" || strings.ContainsAny(attr, " \n") {
+				return ErrInvalidListEntry(ref.Name + " " + attr)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (r listRefSlice) writeTo(w io.Writer, keywords []ListKeyword) error {
 	var buf bytes.Buffer
 	var err error
 
@@ -51,7 +174,9 @@ func (r listRefSlice) writeTo(w io.Writer) error {
 			return
 		}
 
-		if ref.Hash != "" {
+		if ref.Unknown {
+			writeRune('?')
+		} else if ref.Hash != "" {
 			writeString(ref.Hash)
 		} else if ref.Sym != "" {
 			writeRune('@')
@@ -68,6 +193,21 @@ func (r listRefSlice) writeTo(w io.Writer) error {
 			writeString("unchanged")
 		}
 
+		for _, attr := range ref.Attrs {
+			writeRune(' ')
+			writeString(attr)
+		}
+
+		writeRune('\n')
+	}
+
+	for _, kw := range keywords {
+		writeRune(':')
+		writeString(kw.Name)
+		if kw.Value != "" {
+			writeRune(' ')
+			writeString(kw.Value)
+		}
 		writeRune('\n')
 	}
 
@@ -111,18 +251,7 @@ func (r pushRefSlice) writeTo(w io.Writer) error {
 			return
 		}
 
-		if ref.Ok {
-			writeString("ok ")
-			writeString(ref.Dst)
-		} else {
-			writeString("error ")
-			writeString(ref.Dst)
-			if ref.Err != nil {
-				writeRune(' ')
-				writeString(ref.Err.Error())
-			}
-		}
-
+		writeString(ref.statusLine())
 		writeRune('\n')
 	}
 
@@ -0,0 +1,38 @@
+package gitremote
+
+import "testing"
+
+func TestCheckExpect(t *testing.T) {
+	const (
+		current = "0123456789abcdef0123456789abcdef01234567"
+		other   = "89abcdef0123456789abcdef0123456789abcdef"
+	)
+
+	tests := []struct {
+		name    string
+		cas     []string
+		current string
+		ok      bool
+	}{
+		{"no lease", nil, current, true},
+		{"lease matches", []string{"refs/heads/x:" + current}, current, true},
+		{"lease is stale", []string{"refs/heads/x:" + other}, current, false},
+		{"must not exist, exists", []string{"refs/heads/x:"}, current, false},
+		{"must not exist, missing", []string{"refs/heads/x:"}, "", true},
+		{"zero hash, missing", []string{"refs/heads/x:" + SHA1.ZeroHash()}, "", true},
+		{"other ref", []string{"refs/heads/y:" + other}, current, true},
+	}
+
+	for _, test := range tests {
+		ref := &PushRef{Dst: "refs/heads/x"}
+		attachExpectations([]*PushRef{ref}, test.cas, SHA1)
+
+		ok := ref.CheckExpect(test.current)
+		if ok != test.ok {
+			t.Errorf("%s: CheckExpect = %v, want %v", test.name, ok, test.ok)
+		}
+		if !ok && ref.Status != PushRejectedStale {
+			t.Errorf("%s: status = %s, want %s", test.name, ref.Status, PushRejectedStale)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package remotetest
+
+import (
+	"io"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/fd/go-git-remote-helper"
+)
+
+// connectHelper falls back, echoes a single request or falls back after
+// using the connection, depending on mode.
+type connectHelper struct {
+	testHelper
+	mode string
+}
+
+func (h *connectHelper) Capabilities() gitremote.Capabilities {
+	return gitremote.Capabilities{
+		Mandatory: gitremote.CapFetch,
+		Optional:  gitremote.CapConnect | gitremote.CapPush,
+	}
+}
+
+func (h *connectHelper) Fetch(ctx context.Context, cmd *gitremote.CmdFetch) (gitremote.FetchResult, error) {
+	return gitremote.FetchResult{}, nil
+}
+
+func (h *connectHelper) Connect(ctx context.Context, cmd *gitremote.CmdConnect) error {
+	switch h.mode {
+	case "fallback":
+		return gitremote.ErrFallback
+
+	case "late-fallback":
+		_, err := io.WriteString(cmd, "0000")
+		if err != nil {
+			return err
+		}
+		return gitremote.ErrFallback
+
+	default:
+		buf := make([]byte, 5)
+		_, err := io.ReadFull(cmd, buf)
+		if err != nil {
+			return err
+		}
+		_, err = cmd.Write(append([]byte("pong "), buf...))
+		return err
+	}
+}
+
+func TestConnect(t *testing.T) {
+	d := Start(&connectHelper{testHelper: testHelper{refs: 1}}, gitremote.Config{})
+
+	fallback, err := d.Connect("git-upload-pack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fallback {
+		t.Fatal("connect: unexpected fallback")
+	}
+
+	d.Send("ping\n")
+	line, err := d.ReadLine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "pong ping" {
+		t.Errorf("connect: got %q, want %q", line, "pong ping")
+	}
+
+	// Git closes stdin without a blank line after a connect session
+	err = closeWithin(t, d.CloseInput)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConnectFallback(t *testing.T) {
+	d := Start(&connectHelper{testHelper: testHelper{refs: 1}, mode: "fallback"}, gitremote.Config{})
+
+	fallback, err := d.Connect("git-upload-pack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fallback {
+		t.Fatal("connect: expected a fallback")
+	}
+
+	// the session goes on with fetch and push
+	refs, err := d.List(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 1 || refs[0] != testHash+" refs/heads/b000" {
+		t.Errorf("list: got %q", refs)
+	}
+
+	resp, err := d.Fetch(testHash, "refs/heads/b000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp) != 0 {
+		t.Errorf("fetch: got %q", resp)
+	}
+
+	status, err := d.Push("refs/heads/a:refs/heads/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status) != 1 || status[0] != "ok refs/heads/a" {
+		t.Errorf("push: got %q", status)
+	}
+
+	err = closeWithin(t, d.Close)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConnectLateFallback(t *testing.T) {
+	d := Start(&connectHelper{testHelper: testHelper{refs: 1}, mode: "late-fallback"}, gitremote.Config{})
+
+	fallback, err := d.Connect("git-upload-pack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fallback {
+		t.Fatal("connect: the helper used the connection, it cannot fall back")
+	}
+
+	pkt := make([]byte, 4)
+	_, err = io.ReadFull(d.Stdout(), pkt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(pkt) != "0000" {
+		t.Errorf("connect: got %q", pkt)
+	}
+
+	err = closeWithin(t, d.CloseInput)
+	if err != gitremote.ErrLateFallback {
+		t.Errorf("expected ErrLateFallback, got %v", err)
+	}
+}
@@ -0,0 +1,325 @@
+// Package remotetest provides a fake Git for testing remote helpers. A
+// Driver runs a Helper through in-memory pipes and issues commands exactly
+// the way Git's transport-helper does.
+package remotetest
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/fd/go-git-remote-helper"
+	"github.com/fd/go-git-remote-helper/fastimport"
+)
+
+// ErrUnexpectedOutput is returned by Close and CloseInput when the helper
+// wrote output which was never read.
+type ErrUnexpectedOutput string
+
+func (e ErrUnexpectedOutput) Error() string {
+	return fmt.Sprintf("remotetest: unexpected output from helper: %q", string(e))
+}
+
+// Driver plays the role of Git towards a Helper.
+type Driver struct {
+	stdin  *io.PipeWriter
+	outR   *io.PipeReader
+	stdout *bufio.Reader
+	writes chan []byte
+	wrote  chan error
+	done   chan error
+	cancel context.CancelFunc
+	closed bool
+}
+
+// Start runs helper in the background. Config may be used to set Remote,
+// URL and Dir; Helper, Stdin and Stdout are overwritten.
+func Start(helper gitremote.Helper, config gitremote.Config) *Driver {
+	var (
+		inR, inW   = io.Pipe()
+		outR, outW = io.Pipe()
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	d := &Driver{
+		stdin:  inW,
+		outR:   outR,
+		stdout: bufio.NewReader(outR),
+		writes: make(chan []byte, 64),
+		wrote:  make(chan error, 1),
+		done:   make(chan error, 1),
+		cancel: cancel,
+	}
+
+	config.Helper = helper
+	config.Stdin = inR
+	config.Stdout = outW
+	config.Err = nil
+
+	go func() {
+		err := gitremote.Run(ctx, config)
+		inR.CloseWithError(io.ErrClosedPipe)
+		outW.CloseWithError(err)
+		d.done <- err
+	}()
+
+	go d.writeLoop()
+
+	return d
+}
+
+// writeLoop writes to the stdin of the helper so that large inputs (like
+// an export stream) never block the reading side of the driver.
+func (d *Driver) writeLoop() {
+	var err error
+
+	for p := range d.writes {
+		if err == nil {
+			_, err = d.stdin.Write(p)
+		}
+	}
+
+	closeErr := d.stdin.Close()
+	if err == nil {
+		err = closeErr
+	}
+	d.wrote <- err
+}
+
+// Send writes raw data to the stdin of the helper.
+func (d *Driver) Send(s string) {
+	d.writes <- []byte(s)
+}
+
+// SendBytes writes raw data to the stdin of the helper.
+func (d *Driver) SendBytes(p []byte) {
+	d.writes <- append([]byte(nil), p...)
+}
+
+// ReadLine reads a single line (without the newline) from the stdout of the
+// helper.
+func (d *Driver) ReadLine() (string, error) {
+	line, err := d.stdout.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(line, "\n"), nil
+}
+
+// ReadLines reads lines up to (but not including) the next blank line.
+func (d *Driver) ReadLines() ([]string, error) {
+	var lines []string
+
+	for {
+		line, err := d.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+		if line == "" {
+			return lines, nil
+		}
+		lines = append(lines, line)
+	}
+}
+
+// Stdout returns the stdout of the helper, for reading raw responses.
+func (d *Driver) Stdout() *bufio.Reader {
+	return d.stdout
+}
+
+func (d *Driver) Capabilities() ([]string, error) {
+	d.Send("capabilities\n")
+	return d.ReadLines()
+}
+
+// Option sets an option and returns the response of the helper ("ok",
+// "unsupported" or "error <msg>").
+func (d *Driver) Option(key, value string) (string, error) {
+	d.Send("option " + key + " " + value + "\n")
+	return d.ReadLine()
+}
+
+func (d *Driver) List(forPush bool) ([]string, error) {
+	if forPush {
+		d.Send("list for-push\n")
+	} else {
+		d.Send("list\n")
+	}
+	return d.ReadLines()
+}
+
+// Fetch sends a batch of fetch commands. refs holds pairs of hash and ref
+// name. The lines of the response are returned.
+func (d *Driver) Fetch(refs ...string) ([]string, error) {
+	if len(refs) == 0 || len(refs)%2 != 0 {
+		return nil, fmt.Errorf("remotetest: Fetch needs pairs of hash and name")
+	}
+
+	var buf bytes.Buffer
+	for i := 0; i < len(refs); i += 2 {
+		fmt.Fprintf(&buf, "fetch %s %s\n", refs[i], refs[i+1])
+	}
+	buf.WriteByte('\n')
+
+	d.SendBytes(buf.Bytes())
+	return d.ReadLines()
+}
+
+// Push sends a batch of push commands. Each refspec has the form
+// [+]<src>:<dst>. The status lines are returned.
+func (d *Driver) Push(refspecs ...string) ([]string, error) {
+	var buf bytes.Buffer
+	for _, refspec := range refspecs {
+		fmt.Fprintf(&buf, "push %s\n", refspec)
+	}
+	buf.WriteByte('\n')
+
+	d.SendBytes(buf.Bytes())
+	return d.ReadLines()
+}
+
+// Import sends a batch of import commands and parses the resulting
+// fast-import stream up to its done command.
+func (d *Driver) Import(names ...string) ([]fastimport.Command, error) {
+	var buf bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&buf, "import %s\n", name)
+	}
+	buf.WriteByte('\n')
+
+	d.SendBytes(buf.Bytes())
+
+	var (
+		r    = fastimport.NewReader(d.stdout)
+		cmds []fastimport.Command
+	)
+
+	for {
+		cmd, err := r.Next()
+		if err == io.EOF {
+			return cmds, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		cmds = append(cmds, cmd)
+		if _, ok := cmd.(*fastimport.Done); ok {
+			return cmds, nil
+		}
+	}
+}
+
+// Export sends the export command followed by stream (a fast-export stream
+// which should end with "done") and returns the status lines.
+func (d *Driver) Export(stream []byte) ([]string, error) {
+	d.Send("export\n")
+	d.SendBytes(stream)
+	return d.ReadLines()
+}
+
+// Connect sends the connect command. When the helper falls back, fallback
+// is true; otherwise the connection can be used through Send and Stdout.
+func (d *Driver) Connect(service string) (fallback bool, err error) {
+	return d.connect("connect", service)
+}
+
+// StatelessConnect sends the stateless-connect command. See Connect.
+func (d *Driver) StatelessConnect(service string) (fallback bool, err error) {
+	return d.connect("stateless-connect", service)
+}
+
+func (d *Driver) connect(cmd, service string) (bool, error) {
+	d.Send(cmd + " " + service + "\n")
+
+	line, err := d.ReadLine()
+	if err != nil {
+		return false, err
+	}
+
+	switch line {
+	case "":
+		return false, nil
+	case "fallback":
+		return true, nil
+	default:
+		return false, fmt.Errorf("remotetest: unexpected response to %s: %q", cmd, line)
+	}
+}
+
+// Close ends the session the way Git does (a blank line followed by
+// closing stdin) and waits for the helper to return. Output of the helper
+// which was not read is reported as ErrUnexpectedOutput.
+func (d *Driver) Close() error {
+	if d.closed {
+		return nil
+	}
+	d.closed = true
+
+	d.Send("\n")
+	close(d.writes)
+
+	return d.wait()
+}
+
+// CloseInput closes stdin without sending a blank line, as Git does at the
+// end of a connect or stateless-connect session, and waits for the helper
+// to return. See Close.
+func (d *Driver) CloseInput() error {
+	if d.closed {
+		return nil
+	}
+	d.closed = true
+
+	close(d.writes)
+
+	return d.wait()
+}
+
+// wait drains the stdout of the helper, so it never blocks on a write
+// nobody reads, until the helper returns.
+func (d *Driver) wait() error {
+	rest := make(chan []byte, 1)
+	go func() {
+		data, _ := ioutil.ReadAll(d.stdout)
+		rest <- data
+	}()
+
+	err := <-d.done
+	d.cancel()
+	<-d.wrote
+
+	data := <-rest
+	if err == nil && len(data) > 0 {
+		err = ErrUnexpectedOutput(data)
+	}
+
+	return err
+}
+
+// Abort stops the helper without waiting for pending responses, as Git
+// does when it dies. It returns the error of the helper.
+func (d *Driver) Abort() error {
+	if d.closed {
+		return nil
+	}
+	d.closed = true
+
+	d.cancel()
+	d.outR.CloseWithError(io.ErrClosedPipe)
+	close(d.writes)
+
+	err := <-d.done
+	<-d.wrote
+
+	return err
+}
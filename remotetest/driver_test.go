@@ -0,0 +1,128 @@
+package remotetest
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/fd/go-git-remote-helper"
+)
+
+const testHash = "0123456789abcdef0123456789abcdef01234567"
+
+type testHelper struct {
+	gitremote.BaseHelper
+	refs int
+}
+
+func (h *testHelper) Capabilities() gitremote.Capabilities {
+	return gitremote.Capabilities{
+		Mandatory: gitremote.CapFetch,
+		Optional:  gitremote.CapPush | gitremote.CapOption,
+	}
+}
+
+func (h *testHelper) List(ctx context.Context, cmd *gitremote.CmdList) ([]gitremote.ListRef, error) {
+	refs := make([]gitremote.ListRef, h.refs)
+	for i := range refs {
+		refs[i] = gitremote.ListRef{Name: fmt.Sprintf("refs/heads/b%03d", i), Hash: testHash}
+	}
+	return refs, nil
+}
+
+func (h *testHelper) Push(ctx context.Context, cmd *gitremote.CmdPush) error {
+	for _, ref := range cmd.Refs {
+		ref.SetStatus(gitremote.PushOk, nil)
+	}
+	return nil
+}
+
+// closeWithin fails the test when fn does not return within a second.
+func closeWithin(t *testing.T, fn func() error) error {
+	t.Helper()
+
+	done := make(chan error, 1)
+	go func() { done <- fn() }()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(time.Second):
+		t.Fatal("helper did not return")
+		return nil
+	}
+}
+
+func TestDriverSession(t *testing.T) {
+	d := Start(&testHelper{refs: 1}, gitremote.Config{})
+
+	caps, err := d.Capabilities()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(caps, ","), "push,*fetch,option"; got != want {
+		t.Errorf("capabilities: got %q, want %q", got, want)
+	}
+
+	resp, err := d.Option("progress", "true")
+	if err != nil || resp != "ok" {
+		t.Errorf("option: got %q, %v", resp, err)
+	}
+
+	refs, err := d.List(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 1 || refs[0] != testHash+" refs/heads/b000" {
+		t.Errorf("list: got %q", refs)
+	}
+
+	status, err := d.Push("refs/heads/a:refs/heads/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status) != 1 || status[0] != "ok refs/heads/a" {
+		t.Errorf("push: got %q", status)
+	}
+
+	err = closeWithin(t, d.Close)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDriverCloseReportsUnreadOutput(t *testing.T) {
+	for _, n := range []int{1, 500} {
+		d := Start(&testHelper{refs: n}, gitremote.Config{})
+		d.Send("list\n")
+
+		_, err := d.ReadLine()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = closeWithin(t, d.Close)
+		if _, ok := err.(ErrUnexpectedOutput); !ok {
+			t.Errorf("%d refs: expected ErrUnexpectedOutput, got %v", n, err)
+		}
+	}
+}
+
+func TestUnexpectedEOF(t *testing.T) {
+	d := Start(&testHelper{refs: 1}, gitremote.Config{})
+
+	_, err := d.List(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Git died: stdin is closed without the final blank line
+	err = closeWithin(t, d.CloseInput)
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
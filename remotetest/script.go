@@ -0,0 +1,83 @@
+package remotetest
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/fd/go-git-remote-helper"
+)
+
+// RunScript drives helper with a transcript of the conversation Git is
+// expected to have with it:
+//
+//	# comments and blank lines are ignored
+//	> capabilities
+//	< fetch
+//	< option
+//	<
+//	> list
+//	< 0123456789abcdef0123456789abcdef01234567 refs/heads/master
+//	< @refs/heads/master HEAD
+//	<
+//
+// Lines starting with "> " are sent to the helper, lines starting with "< "
+// are expected from the helper. A lone ">" or "<" stands for a blank line.
+// The session is closed the way Git closes it after the last line.
+func RunScript(t testing.TB, helper gitremote.Helper, config gitremote.Config, script string) {
+	t.Helper()
+
+	err := runScript(Start(helper, config), script)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func runScript(d *Driver, script string) error {
+	var (
+		s      = bufio.NewScanner(strings.NewReader(script))
+		lineno int
+	)
+
+	for s.Scan() {
+		lineno++
+
+		line := strings.TrimLeft(s.Text(), " \t")
+
+		switch {
+
+		case line == "", strings.HasPrefix(line, "#"):
+			continue
+
+		case line == ">":
+			d.Send("\n")
+
+		case strings.HasPrefix(line, "> "):
+			d.Send(line[2:] + "\n")
+
+		case line == "<", strings.HasPrefix(line, "< "):
+			var expected string
+			if line != "<" {
+				expected = line[2:]
+			}
+
+			actual, err := d.ReadLine()
+			if err != nil {
+				d.Abort()
+				return fmt.Errorf("script line %d: expected %q: %s", lineno, expected, err)
+			}
+			if actual != expected {
+				d.Abort()
+				return fmt.Errorf("script line %d: expected %q but got %q", lineno, expected, actual)
+			}
+
+		default:
+			d.Abort()
+			return fmt.Errorf("script line %d: invalid line: %q", lineno, line)
+
+		}
+	}
+
+	return d.Close()
+}
@@ -0,0 +1,60 @@
+package remotetest
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/fd/go-git-remote-helper"
+)
+
+func TestRunScript(t *testing.T) {
+	RunScript(t, &testHelper{refs: 2}, gitremote.Config{}, `
+		# a full session
+		> capabilities
+		< push
+		< *fetch
+		< option
+		<
+		> list
+		< `+testHash+` refs/heads/b000
+		< `+testHash+` refs/heads/b001
+		<
+		> push refs/heads/a:refs/heads/a
+		>
+		< ok refs/heads/a
+		<
+	`)
+}
+
+func TestRunScriptErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		err    string
+	}{
+		{
+			name:   "mismatch",
+			script: "> list\n< " + testHash + " refs/heads/other\n",
+			err:    "script line 2: expected",
+		},
+		{
+			name:   "invalid line",
+			script: "list\n",
+			err:    "invalid line",
+		},
+		{
+			name:   "unread output",
+			script: "> list\n< " + testHash + " refs/heads/b000\n",
+			err:    "unexpected output",
+		},
+	}
+
+	for _, test := range tests {
+		d := Start(&testHelper{refs: 500}, gitremote.Config{})
+
+		err := closeWithin(t, func() error { return runScript(d, test.script) })
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected error containing %q, got %v", test.name, test.err, err)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package remotetest
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/fd/go-git-remote-helper"
+	"github.com/fd/go-git-remote-helper/pktline"
+)
+
+// statelessHelper serves a protocol v2 session which acknowledges every
+// command request, or falls back when fallback is set.
+type statelessHelper struct {
+	testHelper
+	fallback bool
+	conn     *ackConn
+}
+
+func (h *statelessHelper) Capabilities() gitremote.Capabilities {
+	return gitremote.Capabilities{
+		Mandatory: gitremote.CapFetch,
+		Optional:  gitremote.CapStatelessConnect,
+	}
+}
+
+func (h *statelessHelper) StatelessConnect(ctx context.Context, cmd *gitremote.CmdStatelessConnect) (gitremote.StatelessConn, error) {
+	if h.fallback {
+		return nil, gitremote.ErrFallback
+	}
+	return h.conn, nil
+}
+
+type ackConn struct {
+	mtx    sync.Mutex
+	rounds int
+	closed bool
+}
+
+func (c *ackConn) Advertisement(ctx context.Context, w io.Writer) error {
+	return pktline.WriteCapabilityAdvertisement(pktline.NewWriter(w), []string{"ls-refs", "fetch"})
+}
+
+func (c *ackConn) RoundTrip(ctx context.Context, req io.Reader, w io.Writer) error {
+	r, err := pktline.ReadCommandRequest(pktline.NewReader(req))
+	if err != nil {
+		return err
+	}
+
+	c.mtx.Lock()
+	c.rounds++
+	c.mtx.Unlock()
+
+	lines := append([]string{"ack " + r.Command}, r.Args...)
+	return pktline.NewWriter(w).WriteLines(lines, pktline.Flush)
+}
+
+func (c *ackConn) Close() error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.closed = true
+	return nil
+}
+
+func (c *ackConn) state() (int, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.rounds, c.closed
+}
+
+func sendCommandRequest(t *testing.T, d *Driver, req *pktline.CommandRequest) {
+	var buf bytes.Buffer
+	err := pktline.WriteCommandRequest(pktline.NewWriter(&buf), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.SendBytes(buf.Bytes())
+}
+
+// readResponse reads the lines of a response up to its flush-pkt and the
+// response-end-pkt which follows it.
+func readResponse(t *testing.T, pr *pktline.Reader) []string {
+	lines, typ, err := pr.ReadLines()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ != pktline.Flush {
+		t.Fatalf("response ended with %s, want %s", typ, pktline.Flush)
+	}
+
+	pkt, err := pr.ReadPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkt.Type != pktline.ResponseEnd {
+		t.Fatalf("got %s after the response, want %s", pkt.Type, pktline.ResponseEnd)
+	}
+
+	return lines
+}
+
+func TestStatelessConnect(t *testing.T) {
+	var (
+		conn = &ackConn{}
+		d    = Start(&statelessHelper{conn: conn}, gitremote.Config{})
+		pr   = pktline.NewReader(d.Stdout())
+	)
+
+	fallback, err := d.StatelessConnect("git-upload-pack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fallback {
+		t.Fatal("stateless-connect: unexpected fallback")
+	}
+
+	caps, err := pktline.ReadCapabilityAdvertisement(pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(caps, ","); got != "ls-refs,fetch" {
+		t.Errorf("advertisement: got %q", got)
+	}
+
+	sendCommandRequest(t, d, &pktline.CommandRequest{Command: "ls-refs", Args: []string{"peel"}})
+	if got := strings.Join(readResponse(t, pr), ","); got != "ack ls-refs,peel" {
+		t.Errorf("round 1: got %q", got)
+	}
+
+	sendCommandRequest(t, d, &pktline.CommandRequest{Command: "fetch", Args: []string{"done"}})
+	if got := strings.Join(readResponse(t, pr), ","); got != "ack fetch,done" {
+		t.Errorf("round 2: got %q", got)
+	}
+
+	// Git closes stdin between rounds to end the session
+	err = closeWithin(t, d.CloseInput)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rounds, closed := conn.state()
+	if rounds != 2 {
+		t.Errorf("got %d rounds, want 2", rounds)
+	}
+	if !closed {
+		t.Error("the connection was not closed")
+	}
+}
+
+func TestStatelessConnectTruncatedRequest(t *testing.T) {
+	var (
+		conn = &ackConn{}
+		d    = Start(&statelessHelper{conn: conn}, gitremote.Config{})
+	)
+
+	_, err := d.StatelessConnect("git-upload-pack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = pktline.ReadCapabilityAdvertisement(pktline.NewReader(d.Stdout()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// stdin is closed in the middle of a request
+	d.Send("0014command=ls-refs\n")
+	err = closeWithin(t, d.CloseInput)
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+	if rounds, closed := conn.state(); rounds != 0 || !closed {
+		t.Errorf("got %d rounds, closed = %v", rounds, closed)
+	}
+}
+
+func TestStatelessConnectFallback(t *testing.T) {
+	d := Start(&statelessHelper{testHelper: testHelper{refs: 1}, fallback: true}, gitremote.Config{})
+
+	fallback, err := d.StatelessConnect("git-upload-pack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fallback {
+		t.Fatal("stateless-connect: expected a fallback")
+	}
+
+	refs, err := d.List(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 1 || refs[0] != testHash+" refs/heads/b000" {
+		t.Errorf("list: got %q", refs)
+	}
+
+	err = closeWithin(t, d.Close)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"os"
 	"sync"
+	"time"
 
 	"golang.org/x/net/context"
 )
@@ -13,19 +14,38 @@ import (
 var ErrInvalidArguments = errors.New("invalid arguments.")
 var ErrUnsupportedOption = errors.New("unsupported option")
 
+// ErrFallback is returned from Connect or StatelessConnect to make Git
+// use the fetch and push commands instead of the wire protocol.
+var ErrFallback = errors.New("fallback to fetch/push")
+
 type Helper interface {
 	Capabilities() Capabilities
 	SetOption(key, value string) error
 
 	List(ctx context.Context, cmd *CmdList) ([]ListRef, error)
-	Fetch(ctx context.Context, cmd *CmdFetch) error
+	Fetch(ctx context.Context, cmd *CmdFetch) (FetchResult, error)
 	Push(ctx context.Context, cmd *CmdPush) error
 	Export(ctx context.Context, cmd *CmdExport) error
 	Import(ctx context.Context, cmd *CmdImport) error
 	Connect(ctx context.Context, cmd *CmdConnect) error
+	StatelessConnect(ctx context.Context, cmd *CmdStatelessConnect) (StatelessConn, error)
 	Unknown(ctx context.Context, cmd *CmdUnknown) error
 }
 
+// StatelessConn proxies a protocol v2 session one request/response round
+// at a time. It is returned by Helper.StatelessConnect.
+type StatelessConn interface {
+	// Advertisement writes the capability advertisement of the service
+	// (pkt-lines terminated by a flush-pkt).
+	Advertisement(ctx context.Context, w io.Writer) error
+
+	// RoundTrip sends a single request (pkt-lines up to and including the
+	// flush-pkt) to the service and writes the response to w.
+	RoundTrip(ctx context.Context, req io.Reader, w io.Writer) error
+
+	Close() error
+}
+
 type Config struct {
 	Helper Helper
 	Dir    string
@@ -33,15 +53,73 @@ type Config struct {
 	URL    string
 	Stdin  io.Reader
 	Stdout io.Writer
+	Stderr io.Writer
 	Err    error
+
+	// Options holds the options set by Git so far. It is managed by the
+	// runner.
+	Options Options
+
+	// Progress renders progress meters on Stderr. The runner sets it for
+	// every command, honouring the progress option.
+	Progress *Progress
+
+	// Signals makes Run stop the session when one of them arrives (see
+	// DefaultSignals and ErrSignal). The running command sees its context
+	// cancelled and has GracePeriod (or DefaultGracePeriod) to return.
+	// When it does not, Run returns ErrSignal anyway and abandons the
+	// session goroutine, which may still read Stdin and write Stdout;
+	// callers must not reuse them after an ErrSignal.
+	Signals     []os.Signal
+	GracePeriod time.Duration
+
+	// Background runs work started ahead of the command which needs it
+	// (see Background). It is set by the runner.
+	Background *Background
+
+	// Pipeline lets list and fetch commands start before the responses to
+	// earlier commands are written; responses are still written in order.
+	// Git waits for every response before it sends the next command, so
+	// this only helps clients which pipeline their commands; use
+	// Background to overlap work with the round trips of Git. Helpers and
+	// middleware must be safe for concurrent use.
+	Pipeline bool
+
+	// Log is the session logger. Commands get a Logger tagged with the
+	// command and remote name, honouring the verbosity option.
+	Log *Logger
+
+	// ObjectFormat is the object format negotiated with Git (see
+	// Capabilities.ObjectFormat).
+	ObjectFormat ObjectFormat
+
+	// Marks is loaded by the runner when the helper declares an
+	// export-marks or import-marks file.
+	Marks *Marks
+
+	// Middleware wraps Helper when the session starts (see Chain).
+	Middleware []Middleware
+
+	// Transcript receives a record of the session when not nil (see
+	// TranscriptEnv and Replay).
+	Transcript io.Writer
+
+	// files are opened by DefaultConfig and closed when Run returns.
+	files []io.Closer
 }
 
 type runner struct {
 	Config
-	mtx sync.Mutex
-	br  *bufio.Reader
-	bw  *bufio.Writer
-	err error
+	mtx      sync.Mutex
+	br       *bufio.Reader
+	bw       *bufio.Writer
+	err      error
+	caps     Capabilities
+	released chan struct{}
+
+	// connected is set by readCommands once a connect or
+	// stateless-connect command was handled.
+	connected bool
 }
 
 func DefaultConfig() Config {
@@ -56,74 +134,179 @@ func DefaultConfig() Config {
 	c.Dir = os.Getenv("GIT_DIR")
 	c.Stdin = os.Stdin
 	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
 	c.Remote = args[0]
+	c.Signals = DefaultSignals
 
 	c.URL = args[0]
 	if len(args) > 1 {
 		c.URL = args[1]
 	}
 
+	transcript, err := transcriptFromEnv()
+	if err != nil {
+		c.Err = err
+		return c
+	}
+	if transcript != nil {
+		c.Transcript = transcript
+		c.files = append(c.files, transcript)
+	}
+
+	logFile, err := logFileFromEnv()
+	if err != nil {
+		c.Err = err
+		return c
+	}
+	c.Log = NewLogger(c.Stderr, logFile)
+	if logFile != nil {
+		c.files = append(c.files, logFile)
+	}
+
 	return c
 }
 
 func Run(ctx context.Context, config Config) error {
+	defer config.closeFiles()
+
 	if config.Err != nil {
 		return config.Err
 	}
 
 	var r runner
 	r.Config = config
+	r.Helper = Chain(r.Helper, r.Middleware...)
+	r.Options = DefaultOptions()
+
+	if r.Log == nil {
+		r.Log = NewLogger(r.Stderr, nil)
+	}
+
+	caps := r.Helper.Capabilities()
+
+	r.ObjectFormat = caps.ObjectFormat
+	if r.ObjectFormat == "" {
+		r.ObjectFormat = SHA1
+	}
+
+	if caps.ExportMarks != "" || caps.ImportMarks != "" {
+		importPath, exportPath := caps.ImportMarks, caps.ExportMarks
+		if importPath == "" {
+			importPath = exportPath
+		}
+		if exportPath == "" {
+			exportPath = importPath
+		}
 
+		marks, err := loadMarks(importPath, exportPath)
+		if err != nil {
+			return err
+		}
+		r.Marks = marks
+	}
+
+	if len(r.Signals) > 0 {
+		return r.runWithSignals(ctx)
+	}
 	return r.run(ctx)
 }
 
+func (c Config) closeFiles() {
+	for _, f := range c.files {
+		f.Close()
+	}
+}
+
 func (r *runner) run(ctx context.Context) error {
+	var (
+		stdin  = r.Stdin
+		stdout = r.Stdout
+	)
+
+	if r.Transcript != nil {
+		rec := newTranscriptRecorder(r.Transcript)
+		defer rec.Flush()
+
+		stdin = io.TeeReader(stdin, rec.Input())
+		stdout = io.MultiWriter(stdout, rec.Output())
+	}
+
 	r.mtx.Lock()
-	r.br = bufio.NewReader(r.Stdin)
-	r.bw = bufio.NewWriter(r.Stdout)
+	r.br = bufio.NewReader(stdin)
+	r.bw = bufio.NewWriter(stdout)
+	r.released = make(chan struct{}, 1)
 	r.mtx.Unlock()
 
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
+	r.Background = newBackground(ctx)
+
 	commands := r.readCommands(ctx)
 
-LOOP:
+	if r.Pipeline {
+		r.runPipelined(ctx, cancel, commands)
+	} else {
+		r.runSerial(ctx, commands)
+	}
+
+	if r.failed() {
+		cancel()
+	}
+	r.setError(r.Background.wait())
+
+	return r.err
+}
+
+func (r *runner) runSerial(ctx context.Context, commands <-chan Command) {
 	for {
 		select {
 
 		case <-ctx.Done():
 			if r.setError(ctx.Err()) {
-				break LOOP
+				return
 			}
 
 		case cmd, ok := <-commands:
 			if !ok {
-				break LOOP
+				return
 			}
 			if r.setError(r.runCommand(ctx, cmd)) {
-				break LOOP
+				return
 			}
 
 		}
 	}
-
-	return r.err
 }
 
 func (r *runner) runCommand(ctx context.Context, cmd Command) error {
-	cmd.setConfig(r.Config)
+	config := r.Config
+	config.Progress = NewProgress(r.Stderr, r.Options.Progress)
+	config.Log = r.Log.With(r.Remote, commandName(cmd), r.Options.Verbosity)
+	cmd.setConfig(config)
 
 	err := cmd.runCommand(r, ctx)
+	r.Options.resetBatch(cmd)
 
 	flushErr := r.bw.Flush()
 	if err == nil {
 		err = flushErr
 	}
 
+	if holdsInput(cmd) {
+		r.released <- struct{}{}
+	}
+
 	return err
 }
 
+func (r *runner) saveMarks() error {
+	if r.Marks == nil {
+		return nil
+	}
+	return r.Marks.Save()
+}
+
 func (r *runner) setError(err error) bool {
 	r.mtx.Lock()
 	defer r.mtx.Unlock()
@@ -0,0 +1,82 @@
+package gitremote
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// DefaultSignals are the signals DefaultConfig asks Run to handle. Git
+// sends SIGINT and SIGTERM to its helpers when it is interrupted. SIGPIPE
+// is left alone: Git going away shows up as a write error on Stdout, and
+// a broken backend connection must stay an ordinary command error.
+var DefaultSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// DefaultGracePeriod is how long Run waits for the running command after a
+// signal when Config.GracePeriod is zero.
+const DefaultGracePeriod = 5 * time.Second
+
+// ErrSignal is returned by Run when the session was ended by a signal.
+type ErrSignal struct {
+	Signal os.Signal
+}
+
+func (e ErrSignal) Error() string {
+	return fmt.Sprintf("killed by signal: %s", e.Signal)
+}
+
+// ExitCode returns the exit status of a process killed by the signal
+// (128 + signal number), which Git reports as death by signal.
+func (e ErrSignal) ExitCode() int {
+	if s, ok := e.Signal.(syscall.Signal); ok {
+		return 128 + int(s)
+	}
+	return 128
+}
+
+// runWithSignals runs the session until it ends or one of Config.Signals
+// arrives. On a signal the context is cancelled and the running command
+// gets the grace period to clean up; a second signal ends the wait early.
+// A command still running after that is abandoned, not waited for.
+func (r *runner) runWithSignals(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, r.Signals...)
+	defer signal.Stop(sigs)
+
+	done := make(chan error, 1)
+	go func() { done <- r.run(ctx) }()
+
+	var sig os.Signal
+	select {
+	case err := <-done:
+		return err
+	case sig = <-sigs:
+	}
+
+	r.Log.Debugf("received %s, shutting down", sig)
+	cancel()
+
+	grace := r.GracePeriod
+	if grace <= 0 {
+		grace = DefaultGracePeriod
+	}
+
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+	case <-sigs:
+	case <-timer.C:
+		r.Log.Errorf("command did not stop within %s", grace)
+	}
+
+	return ErrSignal{Signal: sig}
+}
@@ -0,0 +1,66 @@
+//go:build !windows
+// +build !windows
+
+package gitremote
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// stuckHelper blocks in List until released, ignoring its context.
+type stuckHelper struct {
+	BaseHelper
+	started chan struct{}
+	release chan struct{}
+}
+
+func (h *stuckHelper) Capabilities() Capabilities {
+	return Capabilities{Mandatory: CapFetch}
+}
+
+func (h *stuckHelper) List(ctx context.Context, cmd *CmdList) ([]ListRef, error) {
+	close(h.started)
+	<-h.release
+	return nil, nil
+}
+
+func TestSignalGracePeriod(t *testing.T) {
+	h := &stuckHelper{started: make(chan struct{}), release: make(chan struct{})}
+	defer close(h.release)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- Run(context.Background(), Config{
+			Helper:      h,
+			Stdin:       strings.NewReader("list\n"),
+			Stdout:      ioutil.Discard,
+			Signals:     []os.Signal{syscall.SIGUSR1},
+			GracePeriod: 50 * time.Millisecond,
+		})
+	}()
+
+	<-h.started
+	if err := syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-done:
+		sigErr, ok := err.(ErrSignal)
+		if !ok || sigErr.Signal != syscall.SIGUSR1 {
+			t.Fatalf("Run() = %v, want ErrSignal{SIGUSR1}", err)
+		}
+		if code := sigErr.ExitCode(); code != 128+int(syscall.SIGUSR1) {
+			t.Errorf("ExitCode() = %d, want %d", code, 128+int(syscall.SIGUSR1))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after the grace period")
+	}
+}
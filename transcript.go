@@ -0,0 +1,236 @@
+package gitremote
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/context"
+)
+
+// TranscriptEnv names the environment variable which enables transcript
+// recording. Like GIT_TRACE it accepts "1", "2" or "true" (stderr), a file
+// descriptor between 3 and 9, or an absolute path (appended to).
+const TranscriptEnv = "GIT_TRACE_REMOTE_HELPER"
+
+// TranscriptEntry is a single line of a recorded session, stored as one
+// JSON object per line.
+type TranscriptEntry struct {
+	Time   time.Time `json:"time"`
+	Dir    string    `json:"dir"` // "in" (from Git) or "out" (to Git)
+	Text   string    `json:"text,omitempty"`
+	Binary []byte    `json:"binary,omitempty"` // used when the line is not valid UTF-8
+}
+
+func (e TranscriptEntry) data() []byte {
+	if e.Binary != nil {
+		return e.Binary
+	}
+	return []byte(e.Text)
+}
+
+type ErrTranscriptMismatch struct {
+	Line     int
+	Expected string
+	Actual   string
+}
+
+func (e ErrTranscriptMismatch) Error() string {
+	return fmt.Sprintf("transcript mismatch at output line %d:\n- %q\n+ %q", e.Line, e.Expected, e.Actual)
+}
+
+// transcriptFromEnv opens the transcript named by TranscriptEnv. It
+// returns nil when recording is disabled. Closing the transcript leaves
+// stderr open.
+func transcriptFromEnv() (io.WriteCloser, error) {
+	v := os.Getenv(TranscriptEnv)
+
+	switch strings.ToLower(v) {
+	case "", "0", "false":
+		return nil, nil
+	case "1", "2", "true":
+		return nopCloser{os.Stderr}, nil
+	}
+
+	if fd, err := strconv.Atoi(v); err == nil {
+		if fd < 3 || fd > 9 {
+			return nil, fmt.Errorf("%s: invalid file descriptor %d", TranscriptEnv, fd)
+		}
+		return os.NewFile(uintptr(fd), TranscriptEnv), nil
+	}
+
+	if !strings.HasPrefix(v, "/") {
+		return nil, fmt.Errorf("%s: not an absolute path: %q", TranscriptEnv, v)
+	}
+
+	return os.OpenFile(v, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// transcriptRecorder splits the data passing through the session into
+// lines and writes them as TranscriptEntries.
+type transcriptRecorder struct {
+	mtx sync.Mutex
+	enc *json.Encoder
+	in  bytes.Buffer
+	out bytes.Buffer
+	err error
+}
+
+func newTranscriptRecorder(w io.Writer) *transcriptRecorder {
+	return &transcriptRecorder{enc: json.NewEncoder(w)}
+}
+
+func (t *transcriptRecorder) Input() io.Writer  { return transcriptDir{t, "in"} }
+func (t *transcriptRecorder) Output() io.Writer { return transcriptDir{t, "out"} }
+
+type transcriptDir struct {
+	t   *transcriptRecorder
+	dir string
+}
+
+func (d transcriptDir) Write(p []byte) (int, error) {
+	d.t.write(d.dir, p)
+	// recording errors must never break the session
+	return len(p), nil
+}
+
+func (t *transcriptRecorder) buffer(dir string) *bytes.Buffer {
+	if dir == "in" {
+		return &t.in
+	}
+	return &t.out
+}
+
+func (t *transcriptRecorder) write(dir string, p []byte) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	buf := t.buffer(dir)
+	buf.Write(p)
+
+	for {
+		idx := bytes.IndexByte(buf.Bytes(), '\n')
+		if idx < 0 {
+			return
+		}
+		t.emit(dir, buf.Next(idx+1))
+	}
+}
+
+func (t *transcriptRecorder) emit(dir string, line []byte) {
+	if t.err != nil {
+		return
+	}
+
+	e := TranscriptEntry{Time: time.Now(), Dir: dir}
+	if utf8.Valid(line) {
+		e.Text = string(line)
+	} else {
+		e.Binary = append([]byte(nil), line...)
+	}
+
+	t.err = t.enc.Encode(e)
+}
+
+// Flush writes incomplete lines.
+func (t *transcriptRecorder) Flush() {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	for _, dir := range []string{"in", "out"} {
+		buf := t.buffer(dir)
+		if buf.Len() > 0 {
+			t.emit(dir, buf.Next(buf.Len()))
+		}
+	}
+}
+
+// ReadTranscript reads a recorded transcript.
+func ReadTranscript(r io.Reader) ([]TranscriptEntry, error) {
+	var (
+		dec     = json.NewDecoder(r)
+		entries []TranscriptEntry
+	)
+
+	for {
+		var e TranscriptEntry
+		err := dec.Decode(&e)
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+}
+
+// Replay feeds the input of a recorded transcript to config.Helper and
+// compares the output with the recorded output. ErrTranscriptMismatch
+// describes the first difference.
+func Replay(ctx context.Context, config Config, transcript io.Reader) error {
+	entries, err := ReadTranscript(transcript)
+	if err != nil {
+		return err
+	}
+
+	var in, expected, actual bytes.Buffer
+
+	for _, e := range entries {
+		switch e.Dir {
+		case "in":
+			in.Write(e.data())
+		case "out":
+			expected.Write(e.data())
+		}
+	}
+
+	config.Stdin = &in
+	config.Stdout = &actual
+	config.Transcript = nil
+	config.Err = nil
+
+	err = Run(ctx, config)
+	if err != nil {
+		return err
+	}
+
+	return diffTranscript(expected.String(), actual.String())
+}
+
+func diffTranscript(expected, actual string) error {
+	if expected == actual {
+		return nil
+	}
+
+	var (
+		el = strings.SplitAfter(expected, "\n")
+		al = strings.SplitAfter(actual, "\n")
+	)
+
+	for i := 0; ; i++ {
+		var e, a string
+		if i < len(el) {
+			e = el[i]
+		}
+		if i < len(al) {
+			a = al[i]
+		}
+		if e != a {
+			return ErrTranscriptMismatch{Line: i + 1, Expected: e, Actual: a}
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package gitremote
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// transcriptHelper lists a single ref named name.
+type transcriptHelper struct {
+	BaseHelper
+	name string
+}
+
+func (h *transcriptHelper) Capabilities() Capabilities {
+	return Capabilities{Mandatory: CapFetch}
+}
+
+func (h *transcriptHelper) List(ctx context.Context, cmd *CmdList) ([]ListRef, error) {
+	return []ListRef{{Name: h.name, Hash: SHA1.ZeroHash()}}, nil
+}
+
+func TestTranscriptRoundTrip(t *testing.T) {
+	var (
+		transcript bytes.Buffer
+		h          = &transcriptHelper{name: "refs/heads/\xffbinary"}
+		in         = "capabilities\nlist\n\n"
+	)
+
+	err := Run(context.Background(), Config{Helper: h, Stdin: strings.NewReader(in), Stdout: ioutil.Discard, Transcript: &transcript})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := ReadTranscript(bytes.NewReader(transcript.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// input is read ahead, so only the order within a direction is fixed
+	got := map[string]string{}
+	for _, e := range entries {
+		got[e.Dir] += string(e.data())
+	}
+	if got["in"] != in {
+		t.Errorf("input: got %q, want %q", got["in"], in)
+	}
+	if out := "*fetch\n\n" + SHA1.ZeroHash() + " refs/heads/\xffbinary\n\n"; got["out"] != out {
+		t.Errorf("output: got %q, want %q", got["out"], out)
+	}
+	for _, e := range entries {
+		if strings.Contains(string(e.data()), "\xff") && e.Binary == nil {
+			t.Errorf("invalid UTF-8 line was not recorded as binary: %q", e.Text)
+		}
+	}
+
+	err = Replay(context.Background(), Config{Helper: h}, bytes.NewReader(transcript.Bytes()))
+	if err != nil {
+		t.Errorf("replay: %s", err)
+	}
+
+	err = Replay(context.Background(), Config{Helper: &transcriptHelper{name: "refs/heads/other"}}, bytes.NewReader(transcript.Bytes()))
+	mismatch, ok := err.(ErrTranscriptMismatch)
+	if !ok {
+		t.Fatalf("replay with another helper: expected ErrTranscriptMismatch, got %v", err)
+	}
+	if mismatch.Line != 3 || mismatch.Actual != SHA1.ZeroHash()+" refs/heads/other\n" {
+		t.Errorf("replay with another helper: got %#v", mismatch)
+	}
+}
+
+func TestDiffTranscript(t *testing.T) {
+	tests := []struct {
+		expected, actual string
+		err              error
+	}{
+		{"a\nb\n", "a\nb\n", nil},
+		{"a\nb\n", "a\nc\n", ErrTranscriptMismatch{Line: 2, Expected: "b\n", Actual: "c\n"}},
+		{"a\nb\n", "a\n", ErrTranscriptMismatch{Line: 2, Expected: "b\n", Actual: ""}},
+		{"a\n", "a\nb\n", ErrTranscriptMismatch{Line: 2, Expected: "", Actual: "b\n"}},
+		{"a\nb", "a\nb\n", ErrTranscriptMismatch{Line: 2, Expected: "b", Actual: "b\n"}},
+	}
+
+	for _, test := range tests {
+		err := diffTranscript(test.expected, test.actual)
+		if err != test.err {
+			t.Errorf("diff(%q, %q) = %v, want %v", test.expected, test.actual, err, test.err)
+		}
+	}
+}
+
+func TestTranscriptFromEnv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "transcript")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Unsetenv(TranscriptEnv)
+
+	path := filepath.Join(dir, "session.json")
+
+	tests := []struct {
+		value  string
+		stderr bool
+		file   bool
+		err    bool
+	}{
+		{value: ""},
+		{value: "0"},
+		{value: "false"},
+		{value: "1", stderr: true},
+		{value: "2", stderr: true},
+		{value: "TRUE", stderr: true},
+		{value: "12", err: true},
+		{value: "relative/path", err: true},
+		{value: path, file: true},
+	}
+
+	for _, test := range tests {
+		os.Setenv(TranscriptEnv, test.value)
+
+		w, err := transcriptFromEnv()
+		switch {
+		case test.err:
+			if err == nil {
+				t.Errorf("%q: expected an error", test.value)
+			}
+		case err != nil:
+			t.Errorf("%q: unexpected error: %s", test.value, err)
+		case test.stderr:
+			if w != (nopCloser{os.Stderr}) {
+				t.Errorf("%q: expected stderr, got %v", test.value, w)
+			}
+		case test.file:
+			f, ok := w.(*os.File)
+			if !ok || f.Name() != path {
+				t.Errorf("%q: expected the file, got %v", test.value, w)
+				continue
+			}
+			if err := f.Close(); err != nil {
+				t.Error(err)
+			}
+			if _, err := os.Stat(path); err != nil {
+				t.Errorf("%q: %s", test.value, err)
+			}
+		default:
+			if w != nil {
+				t.Errorf("%q: expected no transcript, got %v", test.value, w)
+			}
+		}
+	}
+}